@@ -35,10 +35,7 @@ func AddCsyncCmd(args []string, remote bool, output *[]string) error {
             return fmt.Errorf("No ip|host for signer %s", signer)
         }
 
-        m := new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeSOA)
-        c := new(dns.Client)
-        r, _, err := c.Exchange(m, ip)
+        r, err := Query(ip, signer, args[0], dns.TypeSOA)
         if err != nil {
             return err
         }
@@ -0,0 +1,184 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "mime"
+    "net/http"
+    "strings"
+
+    "github.com/miekg/dns"
+)
+
+// The prefix of the REST API served on the -http listener
+const ApiPrefix = "/api/v1/"
+
+// The body of POST /api/v1/groups
+type apiGroup struct {
+    Fqdn   string `json:"fqdn"`
+    Parent string `json:"parent"`
+    Port   string `json:"port"`
+}
+
+// The body of POST /api/v1/groups/{fqdn}/signers
+type apiSigner struct {
+    Name    string `json:"name"`
+    NS      string `json:"ns"`
+    Address string `json:"address"`
+    Port    string `json:"port"`
+}
+
+// Serve the REST API, each request is mapped to a command which is executed
+// the same way as Rpc.Call with the identity of the caller (the token given
+// as Authorization: Bearer). Anonymous callers are not accepted and POST
+// requests must be application/json, so that a form on another site can not
+// make requests with the credentials of a browser. GET requests only run
+// commands that do not change the config.
+//
+//    GET  /api/v1/groups                                  group-list
+//    POST /api/v1/groups                                  group-add
+//    GET  /api/v1/groups/{fqdn}/signers                   signer-list
+//    POST /api/v1/groups/{fqdn}/signers                   signer-add
+//    POST /api/v1/groups/{fqdn}/signers/{name}/leave      signer-mark-leave
+//    POST /api/v1/groups/{fqdn}/signers/{name}/unleave    signer-unmark-leave
+//    GET  /api/v1/groups/{fqdn}/status                    status
+//    GET  /api/v1/groups/{fqdn}/status/{check}            status-{check}
+//    GET  /api/v1/groups/{fqdn}/automation/error          automate-error
+//    POST /api/v1/groups/{fqdn}/automation/{action}       automate-{action}
+func ServeApi(w http.ResponseWriter, r *http.Request) {
+    if !IsDaemon {
+        apiError(w, http.StatusServiceUnavailable, "API is only available in daemon mode")
+        return
+    }
+
+    identity := DaemonIdentity(r)
+    if identity == "" || identity == "anonymous" {
+        apiError(w, http.StatusUnauthorized, "Not authenticated")
+        return
+    }
+
+    if r.Method == "POST" {
+        if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+            apiError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+            return
+        }
+    }
+
+    args, status, msg := apiArgs(r)
+    if args == nil {
+        apiError(w, status, msg)
+        return
+    }
+
+    result, err := (&Rpc{Identity: identity, Address: r.RemoteAddr}).call(args)
+    if err != nil {
+        status := http.StatusBadRequest
+        if errors.Is(err, ErrPermissionDenied) {
+            status = http.StatusForbidden
+        }
+        if result == nil {
+            result = &CmdResult{Command: args[0], Output: []string{}}
+        }
+        result.Error = err.Error()
+        apiResult(w, status, result)
+        return
+    }
+    apiResult(w, http.StatusOK, result)
+}
+
+// Map a request to the arguments of a command, returns nil and the HTTP
+// status and message if the request is not valid
+func apiArgs(r *http.Request) ([]string, int, string) {
+    path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, ApiPrefix), "/"), "/")
+    if path[0] != "groups" {
+        return nil, http.StatusNotFound, "Not found"
+    }
+
+    if len(path) == 1 {
+        switch r.Method {
+        case "GET":
+            return []string{"group-list"}, 0, ""
+        case "POST":
+            var g apiGroup
+            if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+                return nil, http.StatusBadRequest, err.Error()
+            }
+            if g.Fqdn == "" || g.Parent == "" {
+                return nil, http.StatusBadRequest, "fqdn and parent are required"
+            }
+            args := []string{"group-add", dns.Fqdn(g.Fqdn), g.Parent}
+            if g.Port != "" {
+                args = append(args, g.Port)
+            }
+            return args, 0, ""
+        }
+        return nil, http.StatusMethodNotAllowed, "Method not allowed"
+    }
+
+    fqdn := dns.Fqdn(path[1])
+    if !Config.ListEntryExists("groups", fqdn) {
+        return nil, http.StatusNotFound, "Group not found"
+    }
+
+    method := r.Method
+    switch {
+    case len(path) == 3 && path[2] == "signers" && method == "GET":
+        return []string{"signer-list", fqdn}, 0, ""
+
+    case len(path) == 3 && path[2] == "signers" && method == "POST":
+        var s apiSigner
+        if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+            return nil, http.StatusBadRequest, err.Error()
+        }
+        if s.Name == "" || s.NS == "" || s.Address == "" {
+            return nil, http.StatusBadRequest, "name, ns and address are required"
+        }
+        args := []string{"signer-add", fqdn, s.Name, dns.Fqdn(s.NS), s.Address}
+        if s.Port != "" {
+            args = append(args, s.Port)
+        }
+        return args, 0, ""
+
+    case len(path) == 5 && path[2] == "signers" && method == "POST":
+        if Config.Get("signer-group:"+path[3], "") != fqdn {
+            return nil, http.StatusNotFound, "Signer not found"
+        }
+        switch path[4] {
+        case "leave":
+            return []string{"signer-mark-leave", path[3]}, 0, ""
+        case "unleave":
+            return []string{"signer-unmark-leave", path[3]}, 0, ""
+        }
+
+    case len(path) == 3 && path[2] == "status" && method == "GET":
+        return []string{"status", fqdn}, 0, ""
+
+    case len(path) == 4 && path[2] == "status" && method == "GET":
+        if _, ok := CommandResult["status-"+path[3]]; ok {
+            return []string{"status-" + path[3], fqdn}, 0, ""
+        }
+
+    case len(path) == 4 && path[2] == "automation" && method == "GET" && path[3] == "error":
+        return []string{"automate-error", fqdn}, 0, ""
+
+    case len(path) == 4 && path[2] == "automation" && method == "POST":
+        switch path[3] {
+        case "start", "stop", "step":
+            return []string{"automate-" + path[3], fqdn}, 0, ""
+        }
+    }
+
+    return nil, http.StatusNotFound, "Not found"
+}
+
+func apiResult(w http.ResponseWriter, status int, result *CmdResult) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(result)
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
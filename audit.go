@@ -0,0 +1,299 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "os/user"
+    "reflect"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The file the audit log is appended to, set from audit-file or <conf>.audit
+var AuditFile string
+
+// Who is changing the config
+type AuditCaller struct {
+    Identity string
+    Address  string
+    Command  []string
+}
+
+// A change of the config
+type AuditEntry struct {
+    Time     string      `json:"time"`
+    Identity string      `json:"identity"`
+    Address  string      `json:"address,omitempty"`
+    Command  []string    `json:"command,omitempty"`
+    Action   string      `json:"action"`
+    Key      string      `json:"key"`
+    Group    string      `json:"group,omitempty"`
+    Signer   string      `json:"signer,omitempty"`
+    Old      interface{} `json:"old,omitempty"`
+    New      interface{} `json:"new,omitempty"`
+}
+
+// The callers currently changing the config by the group they have locked,
+// "" for commands that lock the whole config and run alone (see LockCommand)
+var auditCallers = make(map[string]*AuditCaller)
+var auditCallersLock sync.Mutex
+
+// Register the caller of a command for the changes it makes, group is the
+// group locked by the command (see commandLockGroup). Returns the function
+// to call when the command is done.
+func AuditBegin(group string, caller *AuditCaller) func() {
+    auditCallersLock.Lock()
+    defer auditCallersLock.Unlock()
+
+    previous := auditCallers[group]
+    auditCallers[group] = caller
+    return func() {
+        auditCallersLock.Lock()
+        defer auditCallersLock.Unlock()
+
+        if previous != nil {
+            auditCallers[group] = previous
+        } else {
+            delete(auditCallers, group)
+        }
+    }
+}
+
+// The caller for local commands
+func AuditLocalCaller(args []string) *AuditCaller {
+    identity := "local"
+    if u, err := user.Current(); err == nil {
+        identity += ":" + u.Username
+    }
+    return &AuditCaller{Identity: identity, Command: args}
+}
+
+// Return the caller holding the lock of a group or of the whole config,
+// changes outside of those locks are made by an unknown caller
+func auditCaller(group string) *AuditCaller {
+    auditCallersLock.Lock()
+    defer auditCallersLock.Unlock()
+
+    if c, ok := auditCallers[group]; ok {
+        return c
+    }
+    if c, ok := auditCallers[""]; ok {
+        return c
+    }
+    return &AuditCaller{Identity: "unknown"}
+}
+
+// The prefixes of the keys of groups and signers, see GroupConfig
+var auditGroupPrefixes, auditSignerPrefixes []string
+
+// Keys with the signer as value
+var auditSignerValues = []string{"dnskey-origin:", "ksk-origin:", "ns-origin:"}
+
+// Lists with groups as entries
+var auditGroupLists = map[string]bool{"groups": true, "automate-autostart": true}
+
+func init() {
+    for _, v := range []interface{}{GroupConfig{}, SignerConfig{}} {
+        t := reflect.TypeOf(v)
+        for i := 0; i < t.NumField(); i++ {
+            if t == reflect.TypeOf(GroupConfig{}) {
+                auditGroupPrefixes = append(auditGroupPrefixes, t.Field(i).Tag.Get("conf"))
+            } else {
+                auditSignerPrefixes = append(auditSignerPrefixes, t.Field(i).Tag.Get("conf"))
+            }
+        }
+    }
+}
+
+// Return the group and signer a key belongs to, the config must be locked by
+// the caller
+func (c *config) auditOwner(key string, value interface{}) (group, signer string) {
+    str, _ := value.(string)
+
+    for _, p := range auditGroupPrefixes {
+        if strings.HasPrefix(key, p) {
+            return strings.TrimPrefix(key, p), ""
+        }
+    }
+    for _, p := range auditSignerPrefixes {
+        if strings.HasPrefix(key, p) {
+            signer = strings.TrimPrefix(key, p)
+        }
+    }
+    for _, p := range auditSignerValues {
+        if strings.HasPrefix(key, p) {
+            signer = str
+        }
+    }
+    if signer != "" {
+        group, _ = c.conf["signer-group:"+signer].(string)
+        if group == "" && strings.HasPrefix(key, "signer-group:") {
+            group = str
+        }
+        return group, signer
+    }
+
+    if auditGroupLists[key] {
+        return str, ""
+    }
+    return "", ""
+}
+
+// Record a change of the config, the config must be locked by the caller.
+// The entries are written to the audit log when the config is stored.
+func (c *config) audit(action, key string, old, new interface{}) {
+    value := new
+    if value == nil {
+        value = old
+    }
+    group, signer := c.auditOwner(key, value)
+    if IsSecret(key) {
+        if old != nil {
+            old = SecretRedacted
+        }
+        if new != nil {
+            new = SecretRedacted
+        }
+    }
+
+    caller := auditCaller(group)
+    command := RedactArgs(caller.Command)
+    c.auditPending = append(c.auditPending, AuditEntry{
+        Time:     time.Now().UTC().Format(time.RFC3339),
+        Identity: caller.Identity,
+        Address:  caller.Address,
+        Command:  command,
+        Action:   action,
+        Key:      key,
+        Group:    group,
+        Signer:   signer,
+        Old:      old,
+        New:      new,
+    })
+}
+
+// Record a change of a secret, which is not kept in the config
+func (c *config) AuditSecret(key string, removed bool) {
+    c.m.Lock()
+    defer c.m.Unlock()
+
+    if removed {
+        c.audit("remove", key, SecretRedacted, nil)
+    } else {
+        c.audit("set", key, nil, SecretRedacted)
+    }
+}
+
+// Append entries to the audit log
+func auditWrite(entries []AuditEntry) error {
+    if AuditFile == "" || len(entries) == 0 {
+        return nil
+    }
+
+    f, err := os.OpenFile(AuditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+    if err != nil {
+        return err
+    }
+
+    w := bufio.NewWriter(f)
+    for _, e := range entries {
+        b, err := json.Marshal(e)
+        if err != nil {
+            f.Close()
+            return err
+        }
+        w.Write(b)
+        w.WriteByte('\n')
+    }
+    if err := w.Flush(); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
+
+// Read the audit log, entries are filtered by group or signer if given
+func AuditRead(filter string) ([]AuditEntry, error) {
+    entries := []AuditEntry{}
+
+    f, err := os.Open(AuditFile)
+    if os.IsNotExist(err) {
+        return entries, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for n := 1; scanner.Scan(); n++ {
+        var e AuditEntry
+        if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+            log.Printf("%s:%d: %s", AuditFile, n, err)
+            continue
+        }
+        if filter != "" && e.Group != filter && e.Signer != filter {
+            continue
+        }
+        entries = append(entries, e)
+    }
+    return entries, scanner.Err()
+}
+
+func auditValue(v interface{}) string {
+    switch value := v.(type) {
+    case nil:
+        return "-"
+    case string:
+        return value
+    case []interface{}:
+        s := []string{}
+        for _, e := range value {
+            s = append(s, fmt.Sprint(e))
+        }
+        return "[" + strings.Join(s, " ") + "]"
+    }
+    return fmt.Sprint(v)
+}
+
+func init() {
+    Command["audit-log"] = CmdOutput(AuditLogCmd)
+    CommandHelp["audit-log"] = "Show the changes made to the config and who made them, optional [group|signer] to only show changes of it"
+    CommandResult["audit-log"] = AuditLogCmd
+}
+
+func AuditLogCmd(args []string, remote bool, result *CmdResult) error {
+    filter := ""
+    if len(args) > 0 {
+        filter = args[0]
+    }
+
+    entries, err := AuditRead(filter)
+    if err != nil {
+        return err
+    }
+
+    for _, e := range entries {
+        who := e.Identity
+        if e.Address != "" {
+            who += " (" + e.Address + ")"
+        }
+        line := fmt.Sprintf("%s %s", e.Time, who)
+        if len(e.Command) > 0 {
+            line += " [" + strings.Join(e.Command, " ") + "]"
+        }
+        line += fmt.Sprintf(": %s %s %s -> %s", e.Action, e.Key, auditValue(e.Old), auditValue(e.New))
+        result.Output = append(result.Output, line)
+    }
+
+    return result.SetData(entries)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestAuditCaller(t *testing.T) {
+    c := NewConfig()
+    c.conf["signer-group:s1"] = "example.com."
+    identities := func() []string {
+        l := []string{}
+        for _, e := range c.auditPending {
+            l = append(l, e.Identity)
+        }
+        c.auditPending = nil
+        return l
+    }
+
+    // automation of one group does not make changes of other groups or
+    // the rest of the config
+    end := AuditBegin("example.com.", &AuditCaller{Identity: "automation"})
+    c.Set("automate-stage:example.com.", "ready")
+    c.Set("signer-ns:s1", "ns1.example.net.")
+    c.Set("automate-stage:example.net.", "ready")
+    c.Set("sig-min-validity", "24h")
+    end()
+    expected := []string{"automation", "automation", "unknown", "unknown"}
+    if l := identities(); !reflect.DeepEqual(l, expected) {
+        t.Errorf("changes made by %v, expected %v", l, expected)
+    }
+
+    // a command that locks the whole config runs alone and makes all changes
+    end = AuditBegin("", &AuditCaller{Identity: "local:test"})
+    c.Set("automate-stage:example.net.", "error")
+    c.Set("sig-min-validity", "12h")
+    end()
+    if l := identities(); len(l) != 2 || l[0] != "local:test" || l[1] != "local:test" {
+        t.Errorf("changes made by %v, expected local:test", l)
+    }
+
+    c.Set("sig-min-validity", "6h")
+    if l := identities(); len(l) != 1 || l[0] != "unknown" {
+        t.Errorf("change after the command made by %v, expected unknown", l)
+    }
+}
@@ -1,15 +1,30 @@
 package main
 
 import (
+    "context"
     "fmt"
     "log"
+    "os/exec"
+    "strings"
+    "sync"
     "time"
 )
 
+// How long the notify command may run
+const AutomateNotifyTimeout = 30 * time.Second
+
+// The number of notifications that can be waiting for the notify command
+const AutomateNotifyQueue = 100
+
+// The command run on automation notifications, set with -notify. It is not
+// taken from the config as remote callers may be allowed to change it.
+var AutomateNotifyCommand string
+
 const AutomateReady = "ready"
 const AutomateManual = "manual"
 const AutomateError = "error"
 
+const AutomateJoinPreflight = "join-preflight"
 const AutomateJoinSyncDnskeys = "join-sync-dnskeys"
 const AutomateJoinDnskeysSynced = "join-dnskeys-synced"
 const AutomateJoinSyncCdscdnskeys = "join-sync-cdscdnskeys"
@@ -36,24 +51,36 @@ const AutomateLeaveCdscdnskeysSynced = "leave-cdscdnskeys-synced"
 const AutomateLeaveParentDsSynced = "leave-parent-ds-synced"
 const AutomateLeaveRemoveCdscdnskeys = "leave-remove-cdscdnskeys"
 
-type automation struct {
-    Group   string
-    Running bool
-    Stop    bool
-}
-
-var Automation map[string]*automation
+const AutomateRolloverZskSyncDnskeys = "rollover-zsk-sync-dnskeys"
+const AutomateRolloverZskDnskeysSynced = "rollover-zsk-dnskeys-synced"
+const AutomateRolloverZskWaitDnskey = "rollover-zsk-wait-dnskey"
+const AutomateRolloverZskRetired = "rollover-zsk-retired"
+const AutomateRolloverZskWaitSignatures = "rollover-zsk-wait-signatures"
+const AutomateRolloverZskRemoveDnskeys = "rollover-zsk-remove-dnskeys"
+const AutomateRolloverZskDnskeysRemoved = "rollover-zsk-dnskeys-removed"
+
+const AutomateRolloverKskSyncCdscdnskeys = "rollover-ksk-sync-cdscdnskeys"
+const AutomateRolloverKskCdscdnskeysSynced = "rollover-ksk-cdscdnskeys-synced"
+const AutomateRolloverKskParentDsSynced = "rollover-ksk-parent-ds-synced"
+const AutomateRolloverKskRemoveCdscdnskeys = "rollover-ksk-remove-cdscdnskeys"
+const AutomateRolloverKskWaitDs = "rollover-ksk-wait-ds"
+const AutomateRolloverKskRetired = "rollover-ksk-retired"
+const AutomateRolloverKskWaitDnskey = "rollover-ksk-wait-dnskey"
+const AutomateRolloverKskWithdrawSyncCdscdnskeys = "rollover-ksk-withdraw-sync-cdscdnskeys"
+const AutomateRolloverKskWithdrawCdscdnskeysSynced = "rollover-ksk-withdraw-cdscdnskeys-synced"
+const AutomateRolloverKskWithdrawParentDsSynced = "rollover-ksk-withdraw-parent-ds-synced"
+const AutomateRolloverKskWithdrawRemoveCdscdnskeys = "rollover-ksk-withdraw-remove-cdscdnskeys"
 
 func init() {
-    Automation = make(map[string]*automation)
-
     Command["automate-step"] = AutomateStepCmd
     Command["automate-start"] = AutomateStartCmd
     Command["automate-stop"] = AutomateStopCmd
-    Command["automate-error"] = AutomateErrorCmd
+    Command["automate-error"] = CmdOutput(AutomateErrorCmd)
     Command["automate-clear-error"] = AutomateClearErrorCmd
     Command["automate-autostart"] = AutomateAutostartCmd
     Command["automate-no-autostart"] = AutomateNoAutostartCmd
+    Command["automate-stages"] = AutomateStagesCmd
+    Command["automate-list"] = CmdOutput(AutomateListCmd)
 
     CommandHelp["automate-step"] = "Run one step of automation for a group, requires <fqdn>"
     CommandHelp["automate-start"] = "Start automation for a group, requires <fqdn> (only in daemon mode)"
@@ -62,6 +89,11 @@ func init() {
     CommandHelp["automate-clear-error"] = "Clear the automation error for a group and continue, requires <fqdn> <next step>"
     CommandHelp["automate-autostart"] = "Set automation autostart for a group, requires <fqdn>"
     CommandHelp["automate-no-autostart"] = "Remove automation autostart for a group, requires <fqdn>"
+    CommandHelp["automate-stages"] = "Show the stages of the automation workflows, optional [workflow]"
+    CommandHelp["automate-list"] = "List the automation of all groups with its state, last step and last error (only in daemon mode)"
+
+    CommandResult["automate-error"] = AutomateErrorCmd
+    CommandResult["automate-list"] = AutomateListCmd
 }
 
 func AutomateStepCmd(args []string, remote bool, output *[]string) error {
@@ -81,328 +113,153 @@ func AutomateStepCmd(args []string, remote bool, output *[]string) error {
     }
     WsStatus(args[0], stage, signers)
 
-    switch stage {
-    case AutomateReady:
-        *output = append(*output, "Nothing to do for "+args[0])
-        return nil
-
-    case AutomateError:
-        *output = append(*output, "Error exist for "+args[0])
-        return nil
-
-    case AutomateJoinSyncDnskeys:
-        err := SyncDnskeyCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinDnskeysSynced)
-
-    case AutomateJoinDnskeysSynced:
-        err := StatusCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        if synced := Config.Get("group-dnskeys-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "DNSKEYs not synced yet for "+args[0])
-            Config.Set("automate-stage:"+args[0], AutomateJoinSyncDnskeys)
-            return nil
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinSyncCdscdnskeys)
-
-    case AutomateJoinSyncCdscdnskeys:
-        err := SyncCdscdnskeysCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinCdscdnskeysSynced)
-
-    case AutomateJoinCdscdnskeysSynced:
-        err := StatusCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        if synced := Config.Get("group-cdscdnskeys-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "CDS/CDNSKEYs not synced yet for "+args[0])
-            Config.Set("automate-stage:"+args[0], AutomateJoinSyncCdscdnskeys)
-            return nil
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinParentDsSynced)
-
-    case AutomateJoinParentDsSynced:
-        err := StatusCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        if synced := Config.Get("group-parent-ds-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "Parent DS not synced yet for "+args[0])
-            return nil
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinRemoveCdscdnskeys)
+    step := GetAutomateStage(stage)
+    if step == nil {
+        return fmt.Errorf("Unknown automate stage %s", stage)
+    }
 
-    case AutomateJoinRemoveCdscdnskeys:
-        err := RemoveCdscdnskeysCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinWaitDs)
-
-    case AutomateJoinWaitDs:
-        wait_until := Config.Get("group-wait-ds:"+args[0], "")
-        if wait_until == "" {
-            err := WaitDsCmd(args, remote, output)
-            if err != nil {
-                Config.Set("automate-error:"+args[0], err.Error())
-                Config.Set("automate-stage:"+args[0], AutomateError)
+    if step.Action == nil && step.Check == nil {
+        next := ""
+        if step.Detect != nil {
+            var err error
+            if next, err = step.Detect(args[0], output); err != nil {
                 return err
             }
         }
-        until, err := time.Parse(time.RFC3339, Config.Get("group-wait-ds:"+args[0], ""))
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-
-        if time.Now().Before(until) {
-            *output = append(*output, fmt.Sprintf("Wait until %s (%s)", until.String(), time.Until(until).String()))
-            WsWaitUntil(args[0], time.Until(until).String())
+        if next == "" {
+            *output = append(*output, step.Idle+" "+args[0])
             return nil
         }
-        WsWaitUntil(args[0], "done")
-        Config.Remove("group-wait-ds:" + args[0])
-        Config.Set("automate-stage:"+args[0], AutomateJoinSyncNses)
-
-    case AutomateJoinSyncNses:
-        err := SyncNsCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinNsesSynced)
-
-    case AutomateJoinNsesSynced:
-        err := StatusCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        if synced := Config.Get("group-nses-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "NSes not synced yet for "+args[0])
-            Config.Set("automate-stage:"+args[0], AutomateJoinSyncNses)
-            return nil
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinAddCsync)
-
-    case AutomateJoinAddCsync:
-        err := AddCsyncCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinParentNsSynced)
-
-    case AutomateJoinParentNsSynced:
-        err := StatusCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        if synced := Config.Get("group-parent-ns-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "Parent NS not synced yet for "+args[0])
-            return nil
-        }
-        Config.Set("automate-stage:"+args[0], AutomateJoinRemoveCsync)
+        AutomateSetStage(args[0], next)
+        *output = append(*output, "Automate step "+stage+" detected change, next stage "+next)
+        WsStatus(args[0], next, signers)
+        return nil
+    }
 
-    case AutomateJoinRemoveCsync:
-        err := RemoveCsyncCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateReady)
+    if timedOut, err := AutomateCheckTimeout(args[0], step, output); timedOut {
+        return err
+    }
 
-    case AutomateLeaveSyncNses:
-        err := SyncNsCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
+    if step.Action != nil {
+        if err := step.Action(args, remote, output); err != nil {
+            automateFailure(args[0], step, err)
             return err
         }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveNsesSynced)
+    }
 
-    case AutomateLeaveNsesSynced:
-        err := StatusCmd(args, remote, output)
+    if step.Check != nil {
+        ok, err := step.Check(args[0], output)
         if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
+            automateFailure(args[0], step, err)
             return err
         }
-        if synced := Config.Get("group-nses-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "NSes not synced yet for "+args[0])
-            Config.Set("automate-stage:"+args[0], AutomateLeaveSyncNses)
+        if !ok {
+            if step.Retry != "" {
+                AutomateSetStage(args[0], step.Retry)
+            }
             return nil
         }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveAddCsync)
-
-    case AutomateLeaveAddCsync:
-        err := AddCsyncCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveParentNsSynced)
+    }
 
-    case AutomateLeaveParentNsSynced:
-        err := StatusCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        if synced := Config.Get("group-parent-ns-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "Parent NS not synced yet for "+args[0])
-            return nil
-        }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveRemoveCsync)
+    AutomateSetStage(args[0], step.Next)
 
-    case AutomateLeaveRemoveCsync:
-        err := RemoveCsyncCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveWaitNs)
-
-    case AutomateLeaveWaitNs:
-        wait_until := Config.Get("group-wait-ns:"+args[0], "")
-        if wait_until == "" {
-            err := WaitNsCmd(args, remote, output)
-            if err != nil {
-                Config.Set("automate-error:"+args[0], err.Error())
-                Config.Set("automate-stage:"+args[0], AutomateError)
-                return err
-            }
-        }
-        until, err := time.Parse(time.RFC3339, Config.Get("group-wait-ns:"+args[0], ""))
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
+    *output = append(*output, "Automate step "+stage+" success, next stage "+Config.Get("automate-stage:"+args[0], "<unknown>"))
 
-        if time.Now().Before(until) {
-            *output = append(*output, fmt.Sprintf("Wait until %s (%s)", until.String(), time.Until(until).String()))
-            WsWaitUntil(args[0], time.Until(until).String())
-            return nil
+    signers = make(map[string]bool)
+    for _, s := range Config.ListGet("signers:" + args[0]) {
+        if Config.Get("signer-leaving:"+s, "") == "" {
+            signers[s] = false
+        } else {
+            signers[s] = true
         }
-        WsWaitUntil(args[0], "done")
-        Config.Remove("group-wait-ns:" + args[0])
-        Config.Set("automate-stage:"+args[0], AutomateLeaveSyncDnskeys)
+    }
+    WsStatus(args[0], Config.Get("automate-stage:"+args[0], "<unknown>"), signers)
 
-    case AutomateLeaveSyncDnskeys:
-        err := SyncDnskeyCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveDnskeysSynced)
+    return nil
+}
 
-    case AutomateLeaveDnskeysSynced:
-        err := StatusCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        if synced := Config.Get("group-dnskeys-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "DNSKEYs not synced yet for "+args[0])
-            Config.Set("automate-stage:"+args[0], AutomateLeaveSyncDnskeys)
-            return nil
-        }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveSyncCdscdnskeys)
+// Set the stage of a group, records when the group entered it if the stage
+// changed. Moving between a stage and its Retry stage is a retry of the same
+// step and keeps the time, so that the timeout of the stage counts from when
+// the group first entered the loop.
+func AutomateSetStage(fqdn, stage string) {
+    current := Config.Get("automate-stage:"+fqdn, "")
+    if current != stage && !automateRetryLoop(current, stage) {
+        Config.Set("automate-stage-entered:"+fqdn, time.Now().UTC().Format(time.RFC3339))
+    }
+    Config.Set("automate-stage:"+fqdn, stage)
+}
 
-    case AutomateLeaveSyncCdscdnskeys:
-        err := SyncCdscdnskeysCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveCdscdnskeysSynced)
+// Check if moving from one stage to another stays in a Retry loop
+func automateRetryLoop(from, to string) bool {
+    f, t := GetAutomateStage(from), GetAutomateStage(to)
+    if f == nil || t == nil {
+        return false
+    }
+    return f.Retry == to || t.Retry == from
+}
 
-    case AutomateLeaveCdscdnskeysSynced:
-        err := StatusCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        if synced := Config.Get("group-cdscdnskeys-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "CDS/CDNSKEYs not synced yet for "+args[0])
-            Config.Set("automate-stage:"+args[0], AutomateLeaveSyncCdscdnskeys)
-            return nil
-        }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveParentDsSynced)
+type automateNotification struct {
+    fqdn string
+    msg  string
+}
 
-    case AutomateLeaveParentDsSynced:
-        err := StatusCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        if synced := Config.Get("group-parent-ds-synced:"+args[0], ""); synced != "yes" {
-            *output = append(*output, "Parent DS not synced yet for "+args[0])
-            return nil
-        }
-        Config.Set("automate-stage:"+args[0], AutomateLeaveRemoveCdscdnskeys)
+var automateNotifications chan automateNotification
+var automateNotifyStart sync.Once
+var automateNotifyPending sync.WaitGroup
 
-    case AutomateLeaveRemoveCdscdnskeys:
-        err := RemoveCdscdnskeysCmd(args, remote, output)
-        if err != nil {
-            Config.Set("automate-error:"+args[0], err.Error())
-            Config.Set("automate-stage:"+args[0], AutomateError)
-            return err
-        }
-        Config.Set("automate-stage:"+args[0], AutomateReady)
+// Send a notification about the automation of a group to the log, the
+// websocket console and the notify command if configured. The command is run
+// with the group and the message as arguments by a notifier in the
+// background, so that the group is not locked while it runs.
+func AutomateNotify(fqdn, msg string) {
+    log.Println("Automate notification for "+fqdn+":", msg)
+    WsConsole("Automate " + fqdn + ": " + msg)
 
+    if AutomateNotifyCommand == "" {
+        return
+    }
+    automateNotifyStart.Do(func() {
+        automateNotifications = make(chan automateNotification, AutomateNotifyQueue)
+        go automateNotifier()
+    })
+
+    automateNotifyPending.Add(1)
+    select {
+    case automateNotifications <- automateNotification{fqdn, msg}:
     default:
-        return fmt.Errorf("Unknown automate stage %s", stage)
+        automateNotifyPending.Done()
+        log.Println("Automate notify queue full, dropped notification for", fqdn)
     }
+}
 
-    *output = append(*output, "Automate step "+stage+" success, next stage "+Config.Get("automate-stage:"+args[0], "<unknown>"))
-
-    signers = make(map[string]bool)
-    for _, s := range Config.ListGet("signers:" + args[0]) {
-        if Config.Get("signer-leaving:"+s, "") == "" {
-            signers[s] = false
-        } else {
-            signers[s] = true
+// Run the notify command for the notifications in the order they were made
+func automateNotifier() {
+    for n := range automateNotifications {
+        ctx, cancel := context.WithTimeout(context.Background(), AutomateNotifyTimeout)
+        if out, err := exec.CommandContext(ctx, AutomateNotifyCommand, n.fqdn, n.msg).CombinedOutput(); err != nil {
+            log.Printf("Automate notify command %s failed: %s: %s", AutomateNotifyCommand, err, strings.TrimSpace(string(out)))
         }
+        cancel()
+        automateNotifyPending.Done()
     }
-    WsStatus(args[0], Config.Get("automate-stage:"+args[0], "<unknown>"), signers)
+}
 
-    return nil
+// Wait for the notify command to be run for all notifications, used before
+// exiting
+func AutomateNotifyWait() {
+    automateNotifyPending.Wait()
+}
+
+// Store the error and move the group to the failure stage
+func automateFailure(fqdn string, s *AutomateStage, err error) {
+    failure := s.Failure
+    if failure == "" {
+        failure = AutomateError
+    }
+    Config.Set("automate-error:"+fqdn, err.Error())
+    Config.Set("automate-error-stage:"+fqdn, s.Name)
+    AutomateSetStage(fqdn, failure)
 }
 
 func AutomateAutostart() {
@@ -428,52 +285,15 @@ func AutomateStartCmd(args []string, remote bool, output *[]string) error {
         return fmt.Errorf("requires <fqdn>")
     }
 
-    if _, ok := Automation[args[0]]; ok {
-        return fmt.Errorf("Automation for group %s already running", args[0])
-    }
-
     if !Config.ListEntryExists("groups", args[0]) {
         return fmt.Errorf("group %s does not exist", args[0])
     }
 
+    if err := Automation.Start(args[0]); err != nil {
+        return err
+    }
     *output = append(*output, "Starting automation for "+args[0])
 
-    a := &automation{
-        Group:   args[0],
-        Running: true,
-        Stop:    false,
-    }
-    Automation[args[0]] = a
-
-    go func(a *automation) {
-        log.Println("Automating", a.Group)
-        for !a.Stop {
-            time.Sleep(10 * time.Second)
-
-            // Simulate a automate-step command, lock the daemon during
-            DaemonLock.Lock()
-            args := []string{a.Group}
-            output := []string{}
-            err := AutomateStepCmd(args, false, &output)
-            if err != nil {
-                WsConsole("Automation step failed: " + err.Error())
-                log.Println("Automation step failed:", err.Error())
-                DaemonLock.Unlock()
-                continue
-            }
-            if cerr := Config.Store(DaemonConf); cerr != nil {
-                log.Fatal(cerr)
-            }
-            DaemonLock.Unlock()
-            for _, o := range output {
-                WsConsole("Automate " + a.Group + ": " + o)
-                log.Println("Automate "+a.Group+":", o)
-            }
-        }
-        log.Println("Ending automation for", a.Group)
-        a.Running = false
-    }(a)
-
     return nil
 }
 
@@ -486,33 +306,48 @@ func AutomateStopCmd(args []string, remote bool, output *[]string) error {
         return fmt.Errorf("requires <fqdn>")
     }
 
-    if _, ok := Automation[args[0]]; !ok {
-        return fmt.Errorf("Automation for group %s is not running", args[0])
+    if err := Automation.Stop(args[0]); err != nil {
+        return err
     }
-
     *output = append(*output, "Stopping automation for "+args[0])
-    Automation[args[0]].Stop = true
 
     return nil
 }
 
-func AutomateErrorCmd(args []string, remote bool, output *[]string) error {
+type automateErrorData struct {
+    Group string `json:"group"`
+    Stage string `json:"stage"`
+    Error string `json:"error,omitempty"`
+    // The stage that failed and when the group entered the error stage
+    FailedStage string `json:"failed_stage,omitempty"`
+    Since       string `json:"since,omitempty"`
+}
+
+func AutomateErrorCmd(args []string, remote bool, result *CmdResult) error {
     if len(args) < 1 {
         return fmt.Errorf("requires <fqdn>")
     }
 
+    data := automateErrorData{Group: args[0]}
     stage := Config.Get("automate-stage:"+args[0], "")
     switch stage {
     case "":
         return fmt.Errorf("No automation stage found for %s", args[0])
     case AutomateError:
         error := Config.Get("automate-error:"+args[0], "<unknown>")
-        *output = append(*output, "Error during automation for "+args[0]+": "+error)
+        data.Error = error
+        data.FailedStage = Config.Get("automate-error-stage:"+args[0], "")
+        data.Since = Config.Get("automate-stage-entered:"+args[0], "")
+        if data.FailedStage != "" {
+            error = "stage " + data.FailedStage + ": " + error
+        }
+        result.Output = append(result.Output, "Error during automation for "+args[0]+": "+error)
     default:
-        *output = append(*output, "No automation error for "+args[0])
+        result.Output = append(result.Output, "No automation error for "+args[0])
     }
+    data.Stage = stage
 
-    return nil
+    return result.SetData(data)
 }
 
 func AutomateClearErrorCmd(args []string, remote bool, output *[]string) error {
@@ -530,38 +365,13 @@ func AutomateClearErrorCmd(args []string, remote bool, output *[]string) error {
         return nil
     }
 
-    switch args[1] {
-    case AutomateReady:
-    case AutomateJoinSyncDnskeys:
-    case AutomateJoinDnskeysSynced:
-    case AutomateJoinSyncCdscdnskeys:
-    case AutomateJoinCdscdnskeysSynced:
-    case AutomateJoinParentDsSynced:
-    case AutomateJoinRemoveCdscdnskeys:
-    case AutomateJoinWaitDs:
-    case AutomateJoinSyncNses:
-    case AutomateJoinNsesSynced:
-    case AutomateJoinAddCsync:
-    case AutomateJoinParentNsSynced:
-    case AutomateJoinRemoveCsync:
-    case AutomateLeaveSyncNses:
-    case AutomateLeaveNsesSynced:
-    case AutomateLeaveAddCsync:
-    case AutomateLeaveParentNsSynced:
-    case AutomateLeaveRemoveCsync:
-    case AutomateLeaveWaitNs:
-    case AutomateLeaveSyncDnskeys:
-    case AutomateLeaveDnskeysSynced:
-    case AutomateLeaveSyncCdscdnskeys:
-    case AutomateLeaveCdscdnskeysSynced:
-    case AutomateLeaveParentDsSynced:
-    case AutomateLeaveRemoveCdscdnskeys:
-    default:
+    if next := GetAutomateStage(args[1]); next == nil || (next.Workflow == "" && next.Name != AutomateReady) {
         return fmt.Errorf("Invalid next stage %s", args[1])
     }
 
     Config.Remove("automate-error:" + args[0])
-    Config.Set("automate-stage:"+args[0], args[1])
+    Config.Remove("automate-error-stage:" + args[0])
+    AutomateSetStage(args[0], args[1])
     *output = append(*output, "Clear automation error for "+args[0]+" and set next stage to "+args[1])
 
     return nil
@@ -592,3 +402,97 @@ func AutomateNoAutostartCmd(args []string, remote bool, output *[]string) error
 
     return nil
 }
+
+func AutomateStagesCmd(args []string, remote bool, output *[]string) error {
+    workflows := AutomateWorkflows()
+    if len(args) > 0 {
+        if len(AutomateWorkflowStages(args[0])) == 0 {
+            return fmt.Errorf("Workflow %s does not exist", args[0])
+        }
+        workflows = []string{args[0]}
+    }
+
+    for _, workflow := range workflows {
+        *output = append(*output, "Workflow "+workflow+":")
+        for _, s := range AutomateWorkflowStages(workflow) {
+            line := fmt.Sprintf("  %s: next %s", s.Name, s.Next)
+            if s.Retry != "" {
+                line += ", retry " + s.Retry
+            }
+            if s.Failure != "" {
+                line += ", failure " + s.Failure
+            } else {
+                line += ", failure " + AutomateError
+            }
+            if timeout, fallback := AutomateTimeout(s); timeout > 0 {
+                line += fmt.Sprintf(", timeout %s", timeout)
+                if fallback != "" {
+                    line += ", fallback " + fallback
+                }
+            }
+            *output = append(*output, line)
+        }
+    }
+
+    return nil
+}
+
+type automateListEntry struct {
+    Group     string `json:"group"`
+    Stage     string `json:"stage"`
+    Entered   string `json:"stage_entered,omitempty"`
+    State     string `json:"state"`
+    Started   string `json:"started,omitempty"`
+    LastStep  string `json:"last_step,omitempty"`
+    LastError string `json:"last_error,omitempty"`
+    NextStep  string `json:"next_step,omitempty"`
+    Failures  int    `json:"failures,omitempty"`
+}
+
+func AutomateListCmd(args []string, remote bool, result *CmdResult) error {
+    if !remote {
+        return ErrOnlyRemoteCall
+    }
+
+    runners := make(map[string]automateRunner)
+    for _, r := range Automation.List() {
+        runners[r.Group] = r
+    }
+
+    entries := []automateListEntry{}
+    for _, g := range Config.ListGet("groups") {
+        e := automateListEntry{Group: g, Stage: Config.Get("automate-stage:"+g, ""), State: AutomateRunnerStopped}
+        e.Entered = Config.Get("automate-stage-entered:"+g, "")
+        line := ""
+        if e.Entered != "" {
+            line = " since " + e.Entered
+        }
+
+        if r, ok := runners[g]; ok {
+            e.State = r.State
+            e.Started = r.Started.Format(time.RFC3339)
+            line += ", started " + e.Started
+            if !r.LastStep.IsZero() {
+                e.LastStep = r.LastStep.Format(time.RFC3339)
+                line += ", last step " + e.LastStep
+            }
+            if r.State == AutomateRunnerRunning && !r.NextStep.IsZero() {
+                e.NextStep = r.NextStep.Format(time.RFC3339)
+                line += ", next step " + e.NextStep
+            }
+            if r.Failures > 0 {
+                e.Failures = r.Failures
+                line += fmt.Sprintf(", %d failed steps in a row", r.Failures)
+            }
+            if r.LastError != "" {
+                e.LastError = r.LastError
+                line += ", last error: " + e.LastError
+            }
+        }
+
+        entries = append(entries, e)
+        result.Output = append(result.Output, fmt.Sprintf("%s: %s, stage %s%s", g, e.State, e.Stage, line))
+    }
+
+    return result.SetData(entries)
+}
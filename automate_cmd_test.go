@@ -0,0 +1,138 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestAutomateList(t *testing.T) {
+    previous, automation := Config, Automation
+    t.Cleanup(func() { Config, Automation = previous, automation })
+    Config = NewConfig()
+    Automation = &AutomateManager{runners: make(map[string]*automateRunner)}
+
+    Config.ListAdd("groups", "a.example.", false)
+    Config.ListAdd("groups", "b.example.", false)
+    Config.Set("automate-stage:a.example.", AutomateJoinWaitDs)
+    Config.Set("automate-stage-entered:a.example.", "2026-01-02T03:04:05Z")
+    Config.Set("automate-stage:b.example.", AutomateReady)
+
+    started := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+    Automation.runners["a.example."] = &automateRunner{
+        Group:     "a.example.",
+        State:     AutomateRunnerStopped,
+        Started:   started,
+        LastStep:  started.Add(time.Hour),
+        LastError: "timeout",
+        Failures:  2,
+    }
+
+    if err := AutomateListCmd(nil, false, &CmdResult{}); err != ErrOnlyRemoteCall {
+        t.Errorf("local call returned %v", err)
+    }
+
+    result := &CmdResult{}
+    if err := AutomateListCmd(nil, true, result); err != nil {
+        t.Fatal(err)
+    }
+    expected := []string{
+        "a.example.: stopped, stage join-wait-ds since 2026-01-02T03:04:05Z, started 2026-01-02T03:00:00Z, last step 2026-01-02T04:00:00Z, 2 failed steps in a row, last error: timeout",
+        "b.example.: stopped, stage ready",
+    }
+    if !reflect.DeepEqual(result.Output, expected) {
+        t.Errorf("output %q, expected %q", result.Output, expected)
+    }
+}
+
+// Register stages for a test, they are removed when the test ends
+func automateTestStages(t *testing.T, stages ...*AutomateStage) {
+    for _, s := range stages {
+        automateStage[s.Name] = s
+    }
+    t.Cleanup(func() {
+        for _, s := range stages {
+            delete(automateStage, s.Name)
+        }
+    })
+}
+
+func TestAutomateTimeoutRetryLoop(t *testing.T) {
+    previous := Config
+    t.Cleanup(func() { Config = previous })
+    Config = NewConfig()
+
+    checks := 0
+    automateTestStages(t,
+        &AutomateStage{Name: "test-sync", Action: func(args []string, remote bool, output *[]string) error { return nil }, Next: "test-synced"},
+        &AutomateStage{
+            Name:    "test-synced",
+            Action:  func(args []string, remote bool, output *[]string) error { checks++; return nil },
+            Check:   func(fqdn string, output *[]string) (bool, error) { return false, nil },
+            Retry:   "test-sync",
+            Timeout: time.Hour,
+        },
+    )
+
+    fqdn := "example.com."
+    entered := time.Now().Add(-30 * time.Minute).UTC().Format(time.RFC3339)
+    Config.Set("automate-stage:"+fqdn, "test-synced")
+    Config.Set("automate-stage-entered:"+fqdn, entered)
+
+    output := []string{}
+    for i := 0; i < 4; i++ {
+        if err := AutomateStepCmd([]string{fqdn}, false, &output); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if stage := Config.Get("automate-stage:"+fqdn, ""); stage != "test-synced" {
+        t.Fatalf("stage %s after looping", stage)
+    }
+    if e := Config.Get("automate-stage-entered:"+fqdn, ""); e != entered {
+        t.Fatalf("stage entered changed from %s to %s in the retry loop", entered, e)
+    }
+    if checks != 2 {
+        t.Fatalf("stage run %d times, expected 2", checks)
+    }
+
+    // the loop has now been going on for longer than the timeout
+    Config.Set("automate-timeout:test-synced", "20m")
+    if err := AutomateStepCmd([]string{fqdn}, false, &output); err == nil {
+        t.Fatal("no timeout")
+    }
+    if checks != 2 {
+        t.Error("stage run after it timed out")
+    }
+    if stage := Config.Get("automate-stage:"+fqdn, ""); stage != AutomateError {
+        t.Errorf("stage %s after timeout", stage)
+    }
+    if stage := Config.Get("automate-error-stage:"+fqdn, ""); stage != "test-synced" {
+        t.Errorf("error stage %s", stage)
+    }
+}
+
+func TestAutomateTimeoutAction(t *testing.T) {
+    previous := Config
+    t.Cleanup(func() { Config = previous })
+    Config = NewConfig()
+
+    actions := 0
+    automateTestStages(t,
+        &AutomateStage{Name: "test-action", Action: func(args []string, remote bool, output *[]string) error { actions++; return nil }, Next: AutomateReady, Timeout: time.Hour, Fallback: AutomateManual},
+    )
+
+    fqdn := "example.com."
+    Config.Set("automate-stage:"+fqdn, "test-action")
+    Config.Set("automate-stage-entered:"+fqdn, time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339))
+
+    output := []string{}
+    if err := AutomateStepCmd([]string{fqdn}, false, &output); err != nil {
+        t.Fatal(err)
+    }
+    if actions != 0 {
+        t.Error("action run after the stage timed out")
+    }
+    if stage := Config.Get("automate-stage:"+fqdn, ""); stage != AutomateManual {
+        t.Errorf("stage %s after timeout, expected fallback %s", stage, AutomateManual)
+    }
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Default intervals of the automation, see AutomateNextDelay
+const AutomateDefaultInterval = 10 * time.Second
+const AutomateDefaultParentInterval = time.Minute
+const AutomateDefaultBackoffMax = 10 * time.Minute
+
+const AutomateRunnerRunning = "running"
+const AutomateRunnerStopped = "stopped"
+
+// The automation runner of a group, the state is kept after it has been
+// stopped so it can be listed
+type automateRunner struct {
+    Group     string
+    State     string
+    Started   time.Time
+    LastStep  time.Time
+    LastError string
+    NextStep  time.Time
+    Failures  int
+
+    cancel context.CancelFunc
+}
+
+// Manages the automation runners, one goroutine for each group that is
+// running automation
+type AutomateManager struct {
+    m       sync.Mutex
+    runners map[string]*automateRunner
+}
+
+var Automation = &AutomateManager{runners: make(map[string]*automateRunner)}
+
+// Start automation for a group, fails if it is already running
+func (am *AutomateManager) Start(group string) error {
+    am.m.Lock()
+    defer am.m.Unlock()
+
+    if r, ok := am.runners[group]; ok && r.State == AutomateRunnerRunning {
+        return fmt.Errorf("Automation for group %s already running", group)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    r := &automateRunner{
+        Group:   group,
+        State:   AutomateRunnerRunning,
+        Started: time.Now(),
+        cancel:  cancel,
+    }
+    am.runners[group] = r
+
+    go am.run(ctx, r)
+
+    return nil
+}
+
+// Stop automation for a group, a step in progress is finished but no new
+// step is started
+func (am *AutomateManager) Stop(group string) error {
+    am.m.Lock()
+    defer am.m.Unlock()
+
+    r, ok := am.runners[group]
+    if !ok || r.State != AutomateRunnerRunning {
+        return fmt.Errorf("Automation for group %s is not running", group)
+    }
+
+    r.cancel()
+    r.State = AutomateRunnerStopped
+
+    return nil
+}
+
+// Forget a group, stops the automation if it is running
+func (am *AutomateManager) Remove(group string) {
+    am.m.Lock()
+    defer am.m.Unlock()
+
+    if r, ok := am.runners[group]; ok {
+        r.cancel()
+        delete(am.runners, group)
+    }
+}
+
+// Return a copy of all runners sorted by group
+func (am *AutomateManager) List() []automateRunner {
+    am.m.Lock()
+    defer am.m.Unlock()
+
+    runners := []automateRunner{}
+    for _, r := range am.runners {
+        runners = append(runners, *r)
+    }
+    sort.Slice(runners, func(i, j int) bool { return runners[i].Group < runners[j].Group })
+
+    return runners
+}
+
+func (am *AutomateManager) run(ctx context.Context, r *automateRunner) {
+    log.Println("Automating", r.Group)
+    defer log.Println("Ending automation for", r.Group)
+
+    delay := AutomateNextDelay(r.Group, 0)
+    am.m.Lock()
+    r.NextStep = time.Now().Add(delay)
+    am.m.Unlock()
+
+    timer := time.NewTimer(delay)
+    defer timer.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-timer.C:
+        }
+
+        // Simulate a automate-step command, lock the group during
+        unlock := LockGroup(r.Group)
+        if ctx.Err() != nil {
+            unlock()
+            return
+        }
+        args := []string{r.Group}
+        output := []string{}
+        end := AuditBegin(r.Group, &AuditCaller{Identity: "automation", Command: append([]string{"automate-step"}, args...)})
+        err := AutomateStepCmd(args, false, &output)
+        end()
+        // A group in the error stage waits for the operator, back off as
+        // if the step failed
+        failed := err != nil || Config.Get("automate-stage:"+r.Group, "") == AutomateError
+        unlock()
+        if cerr := StoreConfig(); cerr != nil {
+            log.Fatal(cerr)
+        }
+
+        am.m.Lock()
+        r.LastStep = time.Now()
+        r.LastError = ""
+        if err != nil {
+            r.LastError = err.Error()
+        }
+        if failed {
+            r.Failures++
+        } else {
+            r.Failures = 0
+        }
+        delay = AutomateNextDelay(r.Group, r.Failures)
+        r.NextStep = r.LastStep.Add(delay)
+        am.m.Unlock()
+
+        if err != nil {
+            WsConsole("Automation step failed: " + err.Error())
+            log.Println("Automation step failed:", err.Error())
+        } else {
+            for _, o := range output {
+                WsConsole("Automate " + r.Group + ": " + o)
+                log.Println("Automate "+r.Group+":", o)
+            }
+        }
+
+        timer.Reset(delay)
+    }
+}
+
+// Return a duration from the config, def if not set or invalid
+func automateDuration(keys []string, def time.Duration) time.Duration {
+    for _, k := range keys {
+        v := Config.Get(k, "")
+        if v == "" {
+            continue
+        }
+        d, err := time.ParseDuration(v)
+        if err != nil || d <= 0 {
+            log.Printf("Invalid duration in %s: %s", k, v)
+            continue
+        }
+        return d
+    }
+    return def
+}
+
+// Return how long to wait before the next step of a group.
+//
+// The interval is group-automate-interval:<fqdn> or automate-interval, for
+// stages that poll the parent group-automate-parent-interval:<fqdn> or
+// automate-parent-interval is used. After failed steps the interval doubles
+// for each consecutive failure up to automate-backoff-max. In wait stages the
+// automation sleeps until the deadline of the wait.
+func AutomateNextDelay(fqdn string, failures int) time.Duration {
+    interval := automateDuration([]string{"group-automate-interval:" + fqdn, "automate-interval"}, AutomateDefaultInterval)
+
+    if failures > 0 {
+        max := automateDuration([]string{"automate-backoff-max"}, AutomateDefaultBackoffMax)
+        for i := 0; i < failures && interval < max; i++ {
+            interval *= 2
+        }
+        if interval > max {
+            interval = max
+        }
+        return interval
+    }
+
+    stage := GetAutomateStage(Config.Get("automate-stage:"+fqdn, ""))
+    if stage == nil {
+        return interval
+    }
+
+    if stage.Parent {
+        return automateDuration([]string{"group-automate-parent-interval:" + fqdn, "automate-parent-interval"}, AutomateDefaultParentInterval)
+    }
+
+    if stage.Wait != "" {
+        if until, err := time.Parse(time.RFC3339, Config.Get(stage.Wait+fqdn, "")); err == nil {
+            if d := time.Until(until); d > interval {
+                return d
+            }
+        }
+    }
+
+    return interval
+}
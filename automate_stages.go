@@ -0,0 +1,287 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "time"
+)
+
+// A check done after the action of a stage, returns false if the stage is
+// not yet fulfilled
+type AutomateCheck func(fqdn string, output *[]string) (bool, error)
+
+// Detects if a workflow should be started from an idle stage, returns the
+// stage to move to or empty to stay
+type AutomateDetect func(fqdn string, output *[]string) (string, error)
+
+// A stage of the automation.
+//
+// When a step is executed the Action is run first and then the Check, if
+// both succeed the group moves to the Next stage. If the Check is not
+// fulfilled the group moves to the Retry stage, or stays in the current
+// stage if Retry is empty. If either returns an error the group moves to
+// the Failure stage (default error) and the error is stored.
+//
+// Stages without Action and Check are idle, stepping them only outputs
+// the Idle message unless Detect returns a stage to move to.
+//
+// Wait is the group-wait-*: key of a wait stage, the automation sleeps until
+// its deadline. Parent is set for stages that poll the parent, they are
+// stepped at the parent interval.
+//
+// If a stage is stepped after Timeout, counted from when the group entered
+// the stage or the Retry loop it is part of, the group moves to the Fallback
+// stage or if it is empty to the Failure stage, see AutomateTimeout.
+type AutomateStage struct {
+    Name     string
+    Workflow string
+    Idle     string
+    Action   CmdFunc
+    Check    AutomateCheck
+    Detect   AutomateDetect
+    Next     string
+    Retry    string
+    Failure  string
+    Wait     string
+    Parent   bool
+    Timeout  time.Duration
+    Fallback string
+}
+
+// How long a stage that polls the parent may take by default
+const AutomateDefaultParentTimeout = 72 * time.Hour
+
+// How long a signer may take to retire a ZSK by default
+const AutomateDefaultRetireTimeout = 7 * 24 * time.Hour
+
+// All automation stages, workflow stages are listed in the order they are
+// normally executed
+var AutomateStages = []*AutomateStage{
+    {Name: AutomateReady, Idle: "Nothing to do for", Detect: AutomateDetectRollover},
+    {Name: AutomateManual, Idle: "Manual changes in progress for"},
+    {Name: AutomateError, Idle: "Error exist for"},
+
+    {Name: AutomateJoinPreflight, Workflow: "join", Action: PreflightGroupCmd, Next: AutomateJoinSyncDnskeys},
+    {Name: AutomateJoinSyncDnskeys, Workflow: "join", Action: SyncDnskeyCmd, Next: AutomateJoinDnskeysSynced},
+    {Name: AutomateJoinDnskeysSynced, Workflow: "join", Action: AutomateStatus(StatusDnskeys, "group-dnskeys-synced:"), Check: AutomateSynced("group-dnskeys-synced:", "DNSKEYs"), Next: AutomateJoinSyncCdscdnskeys, Retry: AutomateJoinSyncDnskeys},
+    {Name: AutomateJoinSyncCdscdnskeys, Workflow: "join", Action: SyncCdscdnskeysCmd, Next: AutomateJoinCdscdnskeysSynced},
+    {Name: AutomateJoinCdscdnskeysSynced, Workflow: "join", Action: AutomateStatus(StatusCdscdnskeys, "group-cdscdnskeys-synced:"), Check: AutomateSynced("group-cdscdnskeys-synced:", "CDS/CDNSKEYs"), Next: AutomateJoinParentDsSynced, Retry: AutomateJoinSyncCdscdnskeys},
+    {Name: AutomateJoinParentDsSynced, Workflow: "join", Action: AutomateStatus(StatusParentDs, "group-parent-ds-synced:"), Check: AutomateSynced("group-parent-ds-synced:", "Parent DS"), Next: AutomateJoinRemoveCdscdnskeys, Parent: true, Timeout: AutomateDefaultParentTimeout},
+    {Name: AutomateJoinRemoveCdscdnskeys, Workflow: "join", Action: RemoveCdscdnskeysCmd, Next: AutomateJoinWaitDs},
+    {Name: AutomateJoinWaitDs, Workflow: "join", Action: AutomateWaitStart("group-wait-ds:", WaitDsCmd), Check: AutomateWaitDone("group-wait-ds:"), Next: AutomateJoinSyncNses, Wait: "group-wait-ds:"},
+    {Name: AutomateJoinSyncNses, Workflow: "join", Action: SyncNsCmd, Next: AutomateJoinNsesSynced},
+    {Name: AutomateJoinNsesSynced, Workflow: "join", Action: AutomateStatus(StatusNses, "group-nses-synced:"), Check: AutomateSynced("group-nses-synced:", "NSes"), Next: AutomateJoinAddCsync, Retry: AutomateJoinSyncNses},
+    {Name: AutomateJoinAddCsync, Workflow: "join", Action: AddCsyncCmd, Next: AutomateJoinParentNsSynced},
+    {Name: AutomateJoinParentNsSynced, Workflow: "join", Action: AutomateStatus(StatusParentNs, "group-parent-ns-synced:"), Check: AutomateSynced("group-parent-ns-synced:", "Parent NS"), Next: AutomateJoinRemoveCsync, Parent: true, Timeout: AutomateDefaultParentTimeout},
+    {Name: AutomateJoinRemoveCsync, Workflow: "join", Action: RemoveCsyncCmd, Next: AutomateReady},
+
+    {Name: AutomateLeaveSyncNses, Workflow: "leave", Action: SyncNsCmd, Next: AutomateLeaveNsesSynced},
+    {Name: AutomateLeaveNsesSynced, Workflow: "leave", Action: AutomateStatus(StatusNses, "group-nses-synced:"), Check: AutomateSynced("group-nses-synced:", "NSes"), Next: AutomateLeaveAddCsync, Retry: AutomateLeaveSyncNses},
+    {Name: AutomateLeaveAddCsync, Workflow: "leave", Action: AddCsyncCmd, Next: AutomateLeaveParentNsSynced},
+    {Name: AutomateLeaveParentNsSynced, Workflow: "leave", Action: AutomateStatus(StatusParentNs, "group-parent-ns-synced:"), Check: AutomateSynced("group-parent-ns-synced:", "Parent NS"), Next: AutomateLeaveRemoveCsync, Parent: true, Timeout: AutomateDefaultParentTimeout},
+    {Name: AutomateLeaveRemoveCsync, Workflow: "leave", Action: RemoveCsyncCmd, Next: AutomateLeaveWaitNs},
+    {Name: AutomateLeaveWaitNs, Workflow: "leave", Action: AutomateWaitStart("group-wait-ns:", WaitNsCmd), Check: AutomateWaitDone("group-wait-ns:"), Next: AutomateLeaveSyncDnskeys, Wait: "group-wait-ns:"},
+    {Name: AutomateLeaveSyncDnskeys, Workflow: "leave", Action: SyncDnskeyCmd, Next: AutomateLeaveDnskeysSynced},
+    {Name: AutomateLeaveDnskeysSynced, Workflow: "leave", Action: AutomateStatus(StatusDnskeys, "group-dnskeys-synced:"), Check: AutomateSynced("group-dnskeys-synced:", "DNSKEYs"), Next: AutomateLeaveSyncCdscdnskeys, Retry: AutomateLeaveSyncDnskeys},
+    {Name: AutomateLeaveSyncCdscdnskeys, Workflow: "leave", Action: SyncCdscdnskeysCmd, Next: AutomateLeaveCdscdnskeysSynced},
+    {Name: AutomateLeaveCdscdnskeysSynced, Workflow: "leave", Action: AutomateStatus(StatusCdscdnskeys, "group-cdscdnskeys-synced:"), Check: AutomateSynced("group-cdscdnskeys-synced:", "CDS/CDNSKEYs"), Next: AutomateLeaveParentDsSynced, Retry: AutomateLeaveSyncCdscdnskeys},
+    {Name: AutomateLeaveParentDsSynced, Workflow: "leave", Action: AutomateStatus(StatusParentDs, "group-parent-ds-synced:"), Check: AutomateSynced("group-parent-ds-synced:", "Parent DS"), Next: AutomateLeaveRemoveCdscdnskeys, Parent: true, Timeout: AutomateDefaultParentTimeout},
+    {Name: AutomateLeaveRemoveCdscdnskeys, Workflow: "leave", Action: RemoveCdscdnskeysCmd, Next: AutomateReady},
+
+    {Name: AutomateRolloverZskSyncDnskeys, Workflow: "rollover-zsk", Action: SyncDnskeyCmd, Next: AutomateRolloverZskDnskeysSynced},
+    {Name: AutomateRolloverZskDnskeysSynced, Workflow: "rollover-zsk", Action: AutomateStatus(StatusDnskeys, "group-dnskeys-synced:"), Check: AutomateSynced("group-dnskeys-synced:", "DNSKEYs"), Next: AutomateRolloverZskWaitDnskey, Retry: AutomateRolloverZskSyncDnskeys},
+    {Name: AutomateRolloverZskWaitDnskey, Workflow: "rollover-zsk", Action: AutomateWaitStart("group-wait-dnskey:", WaitDnskeyCmd), Check: AutomateWaitDone("group-wait-dnskey:"), Next: AutomateRolloverZskRetired, Wait: "group-wait-dnskey:"},
+    {Name: AutomateRolloverZskRetired, Workflow: "rollover-zsk", Check: AutomateZskRetired, Next: AutomateRolloverZskWaitSignatures, Timeout: AutomateDefaultRetireTimeout, Fallback: AutomateReady},
+    {Name: AutomateRolloverZskWaitSignatures, Workflow: "rollover-zsk", Action: AutomateWaitStart("group-wait-zsk:", WaitZskCmd), Check: AutomateWaitDone("group-wait-zsk:"), Next: AutomateRolloverZskRemoveDnskeys, Wait: "group-wait-zsk:"},
+    {Name: AutomateRolloverZskRemoveDnskeys, Workflow: "rollover-zsk", Action: RemoveRetiredZsksCmd, Next: AutomateRolloverZskDnskeysRemoved},
+    {Name: AutomateRolloverZskDnskeysRemoved, Workflow: "rollover-zsk", Check: AutomateZsksRemoved, Next: AutomateReady, Retry: AutomateRolloverZskRemoveDnskeys},
+
+    {Name: AutomateRolloverKskSyncCdscdnskeys, Workflow: "rollover-ksk", Action: SyncCdscdnskeysCmd, Next: AutomateRolloverKskCdscdnskeysSynced},
+    {Name: AutomateRolloverKskCdscdnskeysSynced, Workflow: "rollover-ksk", Action: AutomateStatus(StatusCdscdnskeys, "group-cdscdnskeys-synced:"), Check: AutomateSynced("group-cdscdnskeys-synced:", "CDS/CDNSKEYs"), Next: AutomateRolloverKskParentDsSynced, Retry: AutomateRolloverKskSyncCdscdnskeys},
+    {Name: AutomateRolloverKskParentDsSynced, Workflow: "rollover-ksk", Action: AutomateStatus(StatusParentDs, "group-parent-ds-synced:"), Check: AutomateSynced("group-parent-ds-synced:", "Parent DS"), Next: AutomateRolloverKskRemoveCdscdnskeys, Parent: true, Timeout: AutomateDefaultParentTimeout},
+    {Name: AutomateRolloverKskRemoveCdscdnskeys, Workflow: "rollover-ksk", Action: RemoveCdscdnskeysCmd, Next: AutomateRolloverKskWaitDs},
+    {Name: AutomateRolloverKskWaitDs, Workflow: "rollover-ksk", Action: AutomateWaitStart("group-wait-ds:", WaitDsCmd), Check: AutomateWaitDone("group-wait-ds:"), Next: AutomateRolloverKskRetired, Wait: "group-wait-ds:"},
+    {Name: AutomateRolloverKskRetired, Workflow: "rollover-ksk", Check: AutomateKskRetired, Next: AutomateRolloverKskWaitDnskey},
+    {Name: AutomateRolloverKskWaitDnskey, Workflow: "rollover-ksk", Action: AutomateWaitStart("group-wait-dnskey:", WaitDnskeyCmd), Check: AutomateWaitDone("group-wait-dnskey:"), Next: AutomateRolloverKskWithdrawSyncCdscdnskeys, Wait: "group-wait-dnskey:"},
+    {Name: AutomateRolloverKskWithdrawSyncCdscdnskeys, Workflow: "rollover-ksk", Action: SyncCdscdnskeysCmd, Next: AutomateRolloverKskWithdrawCdscdnskeysSynced},
+    {Name: AutomateRolloverKskWithdrawCdscdnskeysSynced, Workflow: "rollover-ksk", Action: AutomateStatus(StatusCdscdnskeys, "group-cdscdnskeys-synced:"), Check: AutomateSynced("group-cdscdnskeys-synced:", "CDS/CDNSKEYs"), Next: AutomateRolloverKskWithdrawParentDsSynced, Retry: AutomateRolloverKskWithdrawSyncCdscdnskeys},
+    {Name: AutomateRolloverKskWithdrawParentDsSynced, Workflow: "rollover-ksk", Action: AutomateStatus(StatusParentDs, "group-parent-ds-synced:"), Check: AutomateSynced("group-parent-ds-synced:", "Parent DS"), Next: AutomateRolloverKskWithdrawRemoveCdscdnskeys, Parent: true, Timeout: AutomateDefaultParentTimeout},
+    {Name: AutomateRolloverKskWithdrawRemoveCdscdnskeys, Workflow: "rollover-ksk", Action: AutomateActions(RemoveCdscdnskeysCmd, rolloverForgetRetiredKsks), Next: AutomateReady},
+}
+
+var automateStage map[string]*AutomateStage
+
+func init() {
+    automateStage = make(map[string]*AutomateStage)
+    for _, s := range AutomateStages {
+        if _, ok := automateStage[s.Name]; ok {
+            panic(fmt.Sprintf("automate stage %s declared twice", s.Name))
+        }
+        automateStage[s.Name] = s
+    }
+}
+
+// Return a stage by name, nil if it does not exist
+func GetAutomateStage(name string) *AutomateStage {
+    return automateStage[name]
+}
+
+// Return the names of all workflows in the order they are declared
+func AutomateWorkflows() []string {
+    workflows := []string{}
+    seen := make(map[string]bool)
+    for _, s := range AutomateStages {
+        if s.Workflow != "" && !seen[s.Workflow] {
+            seen[s.Workflow] = true
+            workflows = append(workflows, s.Workflow)
+        }
+    }
+    return workflows
+}
+
+// Return all stages of a workflow
+func AutomateWorkflowStages(workflow string) []*AutomateStage {
+    stages := []*AutomateStage{}
+    for _, s := range AutomateStages {
+        if s.Workflow == workflow {
+            stages = append(stages, s)
+        }
+    }
+    return stages
+}
+
+// Run several commands as the action of one stage, stops at the first error
+func AutomateActions(actions ...CmdFunc) CmdFunc {
+    return func(args []string, remote bool, output *[]string) error {
+        for _, action := range actions {
+            if err := action(args, remote, output); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+}
+
+// Run a status check and set the group-*-synced:<fqdn> flag if the group is
+// in sync, or remove it if not. The status commands only report and leave
+// the flags to the automation.
+func AutomateStatus(check StatusCheck, key string) CmdFunc {
+    return func(args []string, remote bool, output *[]string) error {
+        if len(args) < 1 {
+            return fmt.Errorf("requires <fqdn>")
+        }
+
+        status, err := check(args[0], output)
+        if err != nil {
+            return err
+        }
+
+        if status.Synced() {
+            *output = append(*output, "In sync")
+            Config.Set(key+args[0], "yes")
+        } else {
+            *output = append(*output, "Not in sync")
+            Config.Remove(key + args[0])
+        }
+        return nil
+    }
+}
+
+// Check that a group-*-synced:<fqdn> flag has been set by AutomateStatus
+func AutomateSynced(key, what string) AutomateCheck {
+    return func(fqdn string, output *[]string) (bool, error) {
+        if synced := Config.Get(key+fqdn, ""); synced != "yes" {
+            *output = append(*output, what+" not synced yet for "+fqdn)
+            return false, nil
+        }
+        return true, nil
+    }
+}
+
+// Run the wait command if the group-wait-*:<fqdn> is not set yet
+func AutomateWaitStart(key string, wait CmdFunc) CmdFunc {
+    return func(args []string, remote bool, output *[]string) error {
+        if Config.Get(key+args[0], "") != "" {
+            return nil
+        }
+        return wait(args, remote, output)
+    }
+}
+
+// Check if the time in group-wait-*:<fqdn> has passed, removes it when it has
+func AutomateWaitDone(key string) AutomateCheck {
+    return func(fqdn string, output *[]string) (bool, error) {
+        until, err := time.Parse(time.RFC3339, Config.Get(key+fqdn, ""))
+        if err != nil {
+            return false, err
+        }
+
+        if time.Now().Before(until) {
+            *output = append(*output, fmt.Sprintf("Wait until %s (%s)", until.String(), time.Until(until).String()))
+            WsWaitUntil(fqdn, time.Until(until).String())
+            return false, nil
+        }
+        WsWaitUntil(fqdn, "done")
+        Config.Remove(key + fqdn)
+        return true, nil
+    }
+}
+
+// Return the timeout of a stage and the fallback stage to move to when it is
+// reached, the timeout can be changed with automate-timeout:<stage> (0
+// disables it) and the fallback with automate-fallback:<stage>. Without a
+// fallback the group moves to the Failure stage.
+func AutomateTimeout(s *AutomateStage) (time.Duration, string) {
+    timeout := s.Timeout
+    if v := Config.Get("automate-timeout:"+s.Name, ""); v != "" {
+        d, err := time.ParseDuration(v)
+        if err != nil || d < 0 {
+            log.Printf("Invalid duration in automate-timeout:%s: %s", s.Name, v)
+        } else {
+            timeout = d
+        }
+    }
+
+    fallback := Config.Get("automate-fallback:"+s.Name, s.Fallback)
+    if fallback != "" && GetAutomateStage(fallback) == nil {
+        log.Printf("Invalid stage in automate-fallback:%s: %s", s.Name, fallback)
+        fallback = ""
+    }
+
+    return timeout, fallback
+}
+
+// Check if a group has been in a stage for longer than its timeout before
+// the stage is stepped, if so it is moved to the fallback stage and a
+// notification is sent. Without a
+// fallback the timeout is handled as a failure of the stage and returned as
+// an error.
+func AutomateCheckTimeout(fqdn string, s *AutomateStage, output *[]string) (bool, error) {
+    timeout, fallback := AutomateTimeout(s)
+    if timeout == 0 {
+        return false, nil
+    }
+
+    entered, err := time.Parse(time.RFC3339, Config.Get("automate-stage-entered:"+fqdn, ""))
+    if err != nil {
+        // Stage entered before timestamps were recorded, start counting now
+        Config.Set("automate-stage-entered:"+fqdn, time.Now().UTC().Format(time.RFC3339))
+        return false, nil
+    }
+    if time.Since(entered) < timeout {
+        return false, nil
+    }
+
+    reason := fmt.Sprintf("Stage %s timed out, not fulfilled after %s (entered %s)", s.Name, timeout, entered.Format(time.RFC3339))
+    AutomateNotify(fqdn, reason)
+
+    if fallback == "" {
+        err := fmt.Errorf("%s", reason)
+        automateFailure(fqdn, s, err)
+        return true, err
+    }
+
+    AutomateSetStage(fqdn, fallback)
+    *output = append(*output, reason+", next stage "+fallback)
+    return true, nil
+}
@@ -1,13 +1,86 @@
 package main
 
 import (
+    "encoding/json"
     "fmt"
 )
 
 type CmdFunc func(args []string, remote bool, output *[]string) error
 
+// A command that also returns structured data, used for JSON output
+type CmdResultFunc func(args []string, remote bool, result *CmdResult) error
+
 var Command = make(map[string]CmdFunc)
 var CommandHelp = make(map[string]string)
 
+// Commands that can return structured data, they also need to be in Command
+var CommandResult = make(map[string]CmdResultFunc)
+
+// Commands for a group that also changes global config and needs to run alone
+var CommandGlobal = make(map[string]bool)
+
 var ErrNoRemoteCall = fmt.Errorf("Can not be called remotely")
 var ErrOnlyRemoteCall = fmt.Errorf("Can only be called remotely")
+var ErrPermissionDenied = fmt.Errorf("Permission denied")
+
+// The result of a command, Output holds the same lines as a CmdFunc outputs
+// and Data the structured data if the command supports it.
+//
+// Data is kept as encoded JSON so the result can be sent over RPC as is.
+type CmdResult struct {
+    Command string          `json:"command"`
+    Output  []string        `json:"output"`
+    Data    json.RawMessage `json:"data,omitempty"`
+    Error   string          `json:"error,omitempty"`
+}
+
+func (r *CmdResult) SetData(data interface{}) error {
+    b, err := json.Marshal(data)
+    if err != nil {
+        return err
+    }
+    r.Data = b
+    return nil
+}
+
+// Return a CmdFunc for a CmdResultFunc, the structured data is discarded
+func CmdOutput(f CmdResultFunc) CmdFunc {
+    return func(args []string, remote bool, output *[]string) error {
+        result := &CmdResult{Output: *output}
+        err := f(args, remote, result)
+        *output = result.Output
+        return err
+    }
+}
+
+// Return the group a command is called for, the first argument is either a
+// group or a signer in a group. Empty if the command is not for a group.
+func CommandGroup(args []string) string {
+    if len(args) < 2 {
+        return ""
+    }
+    if Config.ListEntryExists("groups", args[1]) {
+        return args[1]
+    }
+    return Config.Get("signer-group:"+args[1], "")
+}
+
+// Run a command and return its result, the error of the command is also set
+// in the result
+func RunCommand(args []string, remote bool) (*CmdResult, error) {
+    result := &CmdResult{Command: args[0], Output: []string{}}
+
+    var err error
+    if f, ok := CommandResult[args[0]]; ok {
+        err = f(args[1:], remote, result)
+    } else if cmd, ok := Command[args[0]]; ok {
+        err = cmd(args[1:], remote, &result.Output)
+    } else {
+        err = fmt.Errorf("Command does not exist: %s", args[0])
+    }
+    if err != nil {
+        result.Error = err.Error()
+    }
+
+    return result, err
+}
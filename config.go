@@ -1,26 +1,38 @@
 package main
 
 import (
-    "encoding/json"
     "fmt"
-    "io/ioutil"
+    "log"
+    "os"
+    "reflect"
     "strings"
     "sync"
 )
 
+// The config is kept in memory and written to its storage with Store, only
+// the keys changed since the last Store are written by storages that
+// support it
 type config struct {
     m sync.RWMutex
 
     conf map[string]interface{}
 
-    changed bool
+    storage ConfigStorage
+    dirty   map[string]bool
+
+    // Changes not yet written to the audit log, see audit
+    auditPending []AuditEntry
 }
 
 var Config = NewConfig()
 
+// The open lock file of the config, see LockConfigFile
+var configLockFile *os.File
+
 func NewConfig() *config {
     return &config{
-        conf: make(map[string]interface{}),
+        conf:  make(map[string]interface{}),
+        dirty: make(map[string]bool),
     }
 }
 
@@ -43,15 +55,19 @@ func (c *config) Get(name, _default string) string {
     if ret, ok := v.(string); ok {
         return ret
     }
-    panic(fmt.Sprintf("%s is not string", name))
+    log.Printf("Config %s is not a string (%T)", name, v)
+    return _default
 }
 
 func (c *config) Set(name, value string) {
     c.m.Lock()
     defer c.m.Unlock()
 
+    if old, ok := c.conf[name]; !ok || old != value {
+        c.audit("set", name, old, value)
+    }
     c.conf[name] = value
-    c.changed = true
+    c.dirty[name] = true
 }
 
 func (c *config) SetIfNotExists(name, value string) bool {
@@ -59,8 +75,9 @@ func (c *config) SetIfNotExists(name, value string) bool {
     defer c.m.Unlock()
 
     if _, ok := c.conf[name]; !ok {
+        c.audit("set", name, nil, value)
         c.conf[name] = value
-        c.changed = true
+        c.dirty[name] = true
         return true
     }
     return false
@@ -70,17 +87,67 @@ func (c *config) Remove(name string) bool {
     c.m.Lock()
     defer c.m.Unlock()
 
-    _, ok := c.conf[name]
+    old, ok := c.conf[name]
     if !ok {
         return false
     }
 
+    c.audit("remove", name, old, nil)
     delete(c.conf, name)
-    c.changed = true
+    c.dirty[name] = true
 
     return true
 }
 
+// Check that setting a config key does not add problems to the group or
+// signer it belongs to, see configFile.Validate. Problems they already have
+// are not reported and the rest of the config is not validated.
+func (c *config) CheckSet(name, value string) error {
+    c.m.Lock()
+    defer c.m.Unlock()
+
+    keys := []string{name}
+    if err := configCheckTypes(map[string]interface{}{name: value}, keys); err != nil {
+        return err
+    }
+
+    // the owners of the key with the value set and as it was, validated in
+    // both states
+    groups, signers := configOwners(c.conf, keys)
+    old, exists := c.conf[name]
+    c.conf[name] = value
+    g, s := configOwners(c.conf, keys)
+    groups, signers = append(groups, g...), append(signers, s...)
+    after, err := configOwnerProblems(c.conf, groups, signers)
+    if exists {
+        c.conf[name] = old
+    } else {
+        delete(c.conf, name)
+    }
+    if err != nil {
+        return err
+    }
+    before, err := configOwnerProblems(c.conf, groups, signers)
+    if err != nil {
+        before = nil
+    }
+
+    known := make(map[string]bool)
+    for _, p := range before {
+        known[p] = true
+    }
+    added := []string{}
+    for _, p := range after {
+        if !known[p] {
+            added = append(added, p)
+        }
+    }
+    if len(added) > 0 {
+        return fmt.Errorf("invalid value for %s: %s", name, strings.Join(added, ", "))
+    }
+    return nil
+}
+
 // Return a list of config keys based on a prefix
 func (c *config) PrefixKeys(prefix string) []string {
     c.m.Lock()
@@ -95,24 +162,24 @@ func (c *config) PrefixKeys(prefix string) []string {
     return keys
 }
 
-func (c *config) ListExists(name string) bool {
-    c.m.RLock()
-    defer c.m.RUnlock()
-
+// Return a list, a value that is not a list is logged and treated as an
+// empty list. The config must be locked by the caller.
+func (c *config) listGet(name string) []string {
     lp, ok := c.conf[name]
     if !ok {
-        return false
+        return []string{}
     }
 
-    _, ok = lp.([]string)
+    l, ok := lp.([]string)
     if !ok {
-        panic(fmt.Sprintf("%s is not a list (%T)", name, lp))
+        log.Printf("Config %s is not a list (%T)", name, lp)
+        return []string{}
     }
 
-    return true
+    return l
 }
 
-func (c *config) ListEntryExists(name, value string) bool {
+func (c *config) ListExists(name string) bool {
     c.m.RLock()
     defer c.m.RUnlock()
 
@@ -121,12 +188,15 @@ func (c *config) ListEntryExists(name, value string) bool {
         return false
     }
 
-    l, ok := lp.([]string)
-    if !ok {
-        panic(fmt.Sprintf("%s is not a list (%T)", name, lp))
-    }
+    _, ok = lp.([]string)
+    return ok
+}
 
-    for _, v := range l {
+func (c *config) ListEntryExists(name, value string) bool {
+    c.m.RLock()
+    defer c.m.RUnlock()
+
+    for _, v := range c.listGet(name) {
         if v == value {
             return true
         }
@@ -139,17 +209,7 @@ func (c *config) ListGet(name string) []string {
     c.m.RLock()
     defer c.m.RUnlock()
 
-    lp, ok := c.conf[name]
-    if !ok {
-        return []string{}
-    }
-
-    l, ok := lp.([]string)
-    if !ok {
-        panic(fmt.Sprintf("%s is not a list (%T)", name, lp))
-    }
-
-    return l
+    return c.listGet(name)
 }
 
 func (c *config) ListAdd(name, value string, duplicated bool) bool {
@@ -158,13 +218,15 @@ func (c *config) ListAdd(name, value string, duplicated bool) bool {
 
     lp, ok := c.conf[name]
     if !ok {
+        c.audit("list-add", name, nil, value)
         c.conf[name] = []string{value}
-        c.changed = true
+        c.dirty[name] = true
         return true
     }
     l, ok := lp.([]string)
     if !ok {
-        panic(fmt.Sprintf("%s is not a list", name))
+        log.Printf("Config %s is not a list (%T), can not add %s", name, lp, value)
+        return false
     }
 
     if !duplicated {
@@ -175,8 +237,9 @@ func (c *config) ListAdd(name, value string, duplicated bool) bool {
         }
     }
 
+    c.audit("list-add", name, nil, value)
     c.conf[name] = append(l, value)
-    c.changed = true
+    c.dirty[name] = true
 
     return true
 }
@@ -191,7 +254,8 @@ func (c *config) ListRemove(name, value string) bool {
     }
     l, ok := lp.([]string)
     if !ok {
-        panic(fmt.Sprintf("%s is not a list", name))
+        log.Printf("Config %s is not a list (%T), can not remove %s", name, lp, value)
+        return false
     }
 
     n := []string{}
@@ -200,71 +264,114 @@ func (c *config) ListRemove(name, value string) bool {
             n = append(n, v)
         }
     }
+    if len(n) != len(l) {
+        c.audit("list-remove", name, value, nil)
+    }
 
     c.conf[name] = n
-    c.changed = true
+    c.dirty[name] = true
 
     return true
 }
 
-func (c *config) Store(filename string) error {
+// Write the changes to the storage
+func (c *config) Store() error {
     c.m.Lock()
     defer c.m.Unlock()
 
-    if !c.changed {
+    if c.storage == nil {
         return nil
     }
 
-    b, err := json.Marshal(c.conf)
-    if err != nil {
-        return err
+    if len(c.dirty) > 0 {
+        changed := []string{}
+        for k := range c.dirty {
+            changed = append(changed, k)
+        }
+        // the rest of the config was checked when loaded or changed
+        if err := configCheckTypes(c.conf, changed); err != nil {
+            return err
+        }
+        if err := c.storage.Store(c.conf, changed); err != nil {
+            return err
+        }
+
+        c.dirty = make(map[string]bool)
     }
 
-    err = ioutil.WriteFile(filename, b, 0644)
+    // Secrets are not kept in the config, their changes may be the only ones
+    if err := auditWrite(c.auditPending); err != nil {
+        log.Printf("Writing audit log %s: %s", AuditFile, err)
+    }
+    c.auditPending = nil
+
+    return nil
+}
+
+// Open the storage of the config and load it, configs of an older version
+// are migrated and written in the current version with the next Store
+func (c *config) Open(storage ConfigStorage) error {
+    c.m.Lock()
+    defer c.m.Unlock()
+
+    conf, migrated, err := storage.Load()
     if err != nil {
         return err
     }
 
-    c.changed = false
+    c.conf = conf
+    c.storage = storage
+    c.dirty = make(map[string]bool)
+    if migrated {
+        for k := range conf {
+            c.dirty[k] = true
+        }
+    }
 
     return nil
 }
 
-func (c *config) Load(filename string) error {
+// Remove secrets from the previous versions of the config kept by the
+// storage, if it keeps any
+func (c *config) ScrubSecrets() error {
     c.m.Lock()
     defer c.m.Unlock()
 
-    b, err := ioutil.ReadFile(filename)
-    if err != nil {
-        return err
+    if scrubber, ok := c.storage.(configSecretScrubber); ok {
+        return scrubber.ScrubSecrets()
     }
+    return nil
+}
 
-    conf := make(map[string]interface{})
+// Close the storage, the config can not be stored after this
+func (c *config) Close() error {
+    c.m.Lock()
+    defer c.m.Unlock()
 
-    err = json.Unmarshal(b, &conf)
-    if err != nil {
-        return err
+    if c.storage == nil {
+        return nil
     }
+    err := c.storage.Close()
+    c.storage = nil
+    return err
+}
+
+// Replace the whole config, e.g. when importing a config file
+func (c *config) Replace(conf map[string]interface{}) {
+    c.m.Lock()
+    defer c.m.Unlock()
 
+    for k, old := range c.conf {
+        c.dirty[k] = true
+        if _, ok := conf[k]; !ok {
+            c.audit("remove", k, old, nil)
+        }
+    }
     for k, v := range conf {
-        switch l := v.(type) {
-        case []interface{}:
-            n := []string{}
-            for _, e := range l {
-                s, ok := e.(string)
-                if !ok {
-                    panic(fmt.Sprintf("conf broken - list %s has entry that is not string (%T)", k, s))
-                }
-                n = append(n, s)
-            }
-            conf[k] = n
-            break
-        default:
-            break
+        c.dirty[k] = true
+        if old, ok := c.conf[k]; !ok || !reflect.DeepEqual(old, v) {
+            c.audit("set", k, old, v)
         }
     }
-
     c.conf = conf
-
-    return nil
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "time"
+
+    bolt "go.etcd.io/bbolt"
+)
+
+var boltConfigBucket = []byte("config")
+var boltMetaBucket = []byte("meta")
+var boltVersionKey = []byte("version")
+
+// The config in a bbolt database, each key is stored JSON encoded and only
+// the changed keys are written on Store in one transaction
+type boltStorage struct {
+    db *bolt.DB
+}
+
+func NewBoltStorage(filename string) (ConfigStorage, error) {
+    db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+    if err != nil {
+        return nil, fmt.Errorf("%s: %s", filename, err)
+    }
+    return &boltStorage{db: db}, nil
+}
+
+func (s *boltStorage) Load() (map[string]interface{}, bool, error) {
+    conf := make(map[string]interface{})
+
+    err := s.db.View(func(tx *bolt.Tx) error {
+        if meta := tx.Bucket(boltMetaBucket); meta != nil {
+            version, err := strconv.Atoi(string(meta.Get(boltVersionKey)))
+            if err != nil {
+                return fmt.Errorf("invalid config version in database")
+            }
+            if version > ConfigVersion {
+                return fmt.Errorf("config version %d is newer than supported version %d", version, ConfigVersion)
+            }
+        }
+
+        b := tx.Bucket(boltConfigBucket)
+        if b == nil {
+            return nil
+        }
+        return b.ForEach(func(k, v []byte) error {
+            var value interface{}
+            if err := json.Unmarshal(v, &value); err != nil {
+                return fmt.Errorf("%s: %s", k, err)
+            }
+            conf[string(k)] = value
+            return nil
+        })
+    })
+    if err != nil {
+        return nil, false, fmt.Errorf("%s: %s", s.db.Path(), err)
+    }
+
+    if err := configLists(conf); err != nil {
+        return nil, false, fmt.Errorf("%s: %s", s.db.Path(), err)
+    }
+    if err := configValidate(conf, s.db.Path()); err != nil {
+        return nil, false, fmt.Errorf("%s: %s", s.db.Path(), err)
+    }
+
+    return conf, false, nil
+}
+
+func (s *boltStorage) Store(conf map[string]interface{}, changed []string) error {
+    return s.db.Update(func(tx *bolt.Tx) error {
+        meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+        if err != nil {
+            return err
+        }
+        if err := meta.Put(boltVersionKey, []byte(strconv.Itoa(ConfigVersion))); err != nil {
+            return err
+        }
+
+        b, err := tx.CreateBucketIfNotExists(boltConfigBucket)
+        if err != nil {
+            return err
+        }
+        for _, k := range changed {
+            v, ok := conf[k]
+            if !ok {
+                if err := b.Delete([]byte(k)); err != nil {
+                    return err
+                }
+                continue
+            }
+            data, err := json.Marshal(v)
+            if err != nil {
+                return err
+            }
+            if err := b.Put([]byte(k), data); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+func (s *boltStorage) Close() error {
+    return s.db.Close()
+}
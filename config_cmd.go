@@ -2,27 +2,40 @@ package main
 
 import (
     "fmt"
+    "io/ioutil"
 )
 
 func init() {
     Command["conf-list"] = ConfigListCmd
-    CommandHelp["conf-list"] = "List all configured options and their current values"
+    CommandHelp["conf-list"] = "List all configured options and their current values, secrets are redacted"
 
     Command["conf-get"] = ConfigGetCmd
-    CommandHelp["conf-get"] = "Get a config option, requires <name>"
+    CommandHelp["conf-get"] = "Get a config option, requires <name> (secrets are redacted)"
 
     Command["conf-set"] = ConfigSetCmd
-    CommandHelp["conf-set"] = "Set a config option, requires <name> <value>"
+    CommandHelp["conf-set"] = "Set a config option, requires <name> <value> (secrets are stored with the secret provider)"
+
+    Command["conf-import"] = ConfigImportCmd
+    CommandHelp["conf-import"] = "Replace the config with the content of a JSON config file, e.g. to move it to another storage, requires <file>"
 }
 
 func ConfigListCmd(args []string, remote bool, output *[]string) error {
     Config.m.RLock()
-    defer Config.m.RUnlock()
-
     *output = append(*output, "Config:")
     for k, v := range Config.conf {
+        if IsSecret(k) {
+            v = SecretRedacted
+        }
         *output = append(*output, fmt.Sprintf("  %s: %v", k, v))
     }
+    Config.m.RUnlock()
+
+    *output = append(*output, "Secrets:")
+    for _, p := range SecretPrefixes {
+        for _, k := range SecretKeys(p) {
+            *output = append(*output, fmt.Sprintf("  %s: %s", k, SecretRedacted))
+        }
+    }
 
     return nil
 }
@@ -32,6 +45,15 @@ func ConfigGetCmd(args []string, remote bool, output *[]string) error {
         return fmt.Errorf("Missing <name>")
     }
 
+    if IsSecret(args[0]) {
+        if GetSecret(args[0]) == "" {
+            *output = append(*output, fmt.Sprintf("Config %s: ", args[0]))
+        } else {
+            *output = append(*output, fmt.Sprintf("Config %s: %s", args[0], SecretRedacted))
+        }
+        return nil
+    }
+
     *output = append(*output, fmt.Sprintf("Config %s: %s", args[0], Config.Get(args[0], "")))
 
     return nil
@@ -42,9 +64,59 @@ func ConfigSetCmd(args []string, remote bool, output *[]string) error {
         return fmt.Errorf("Missing <name> <value>")
     }
 
-    Config.Set(args[0], args[1])
+    if configIsList(args[0]) {
+        return fmt.Errorf("%s is a list and can not be set", args[0])
+    }
+
+    name := TsigAlgorithmKey(args[0], args[1])
+    if name != args[0] {
+        *output = append(*output, fmt.Sprintf("%s is kept as %s", args[0], name))
+    }
+
+    if IsSecret(name) {
+        if err := SetSecret(name, args[1]); err != nil {
+            return err
+        }
+        *output = append(*output, fmt.Sprintf("Secret %s set", name))
+        return nil
+    }
+
+    if err := Config.CheckSet(name, args[1]); err != nil {
+        return err
+    }
+    Config.Set(name, args[1])
+
+    *output = append(*output, fmt.Sprintf("Config %s set", name))
+
+    return nil
+}
+
+func ConfigImportCmd(args []string, remote bool, output *[]string) error {
+    if remote {
+        return ErrNoRemoteCall
+    }
+    if len(args) < 1 {
+        return fmt.Errorf("Missing <file>")
+    }
+
+    b, err := ioutil.ReadFile(args[0])
+    if err != nil {
+        return err
+    }
+    conf, _, err := configDecode(b)
+    if err != nil {
+        return fmt.Errorf("%s: %s", args[0], err)
+    }
+    if err := configValidate(conf, args[0]); err != nil {
+        return fmt.Errorf("%s: %s", args[0], err)
+    }
+
+    Config.Replace(conf)
+    if err := MoveSecrets(); err != nil {
+        return err
+    }
 
-    *output = append(*output, fmt.Sprintf("Config %s set", args[0]))
+    *output = append(*output, fmt.Sprintf("Config imported from %s", args[0]))
 
     return nil
 }
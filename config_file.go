@@ -0,0 +1,183 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// How many backups of the config file are kept by default, see config-backups
+const ConfigDefaultBackups = 3
+
+// Write the config file so that a crash never leaves a truncated file, the
+// data is written to a temporary file in the same directory, synced to disk
+// and then renamed over the config file. The previous config file is kept as
+// <file>.1 and older backups are rotated up to <file>.<backups>.
+func writeConfigFile(filename string, data []byte, perm os.FileMode, backups int) error {
+    dir, base := filepath.Split(filename)
+    if dir == "" {
+        dir = "."
+    }
+
+    tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Chmod(perm); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+
+    if err := rotateConfigBackups(filename, perm, backups); err != nil {
+        return fmt.Errorf("backup of %s: %s", filename, err)
+    }
+
+    if err := os.Rename(tmp.Name(), filename); err != nil {
+        return err
+    }
+
+    // Sync the directory so the rename survives a crash
+    d, err := os.Open(dir)
+    if err != nil {
+        return err
+    }
+    defer d.Close()
+    return d.Sync()
+}
+
+// Rotate the backups of the config file and link the current file as
+// <file>.1, does nothing if there is no config file yet
+func rotateConfigBackups(filename string, perm os.FileMode, backups int) error {
+    if backups < 1 {
+        return nil
+    }
+    if _, err := os.Stat(filename); os.IsNotExist(err) {
+        return nil
+    }
+
+    for i := backups - 1; i > 0; i-- {
+        from := filename + "." + strconv.Itoa(i)
+        if _, err := os.Stat(from); os.IsNotExist(err) {
+            continue
+        }
+        if err := os.Rename(from, filename+"."+strconv.Itoa(i+1)); err != nil {
+            return err
+        }
+    }
+
+    backup := filename + ".1"
+    os.Remove(backup)
+    if err := os.Link(filename, backup); err == nil {
+        return nil
+    }
+
+    // Hard links not supported, copy it instead
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return err
+    }
+    return ioutil.WriteFile(backup, data, perm)
+}
+
+// Remove secrets from all backups of the config file, the backups are found
+// by name so backups beyond the current config-backups are also scrubbed
+func scrubConfigBackups(filename string) error {
+    backups, err := filepath.Glob(filename + ".*")
+    if err != nil {
+        return err
+    }
+    for _, backup := range backups {
+        if _, err := strconv.Atoi(strings.TrimPrefix(backup, filename+".")); err != nil {
+            continue
+        }
+
+        data, err := ioutil.ReadFile(backup)
+        if err != nil {
+            return err
+        }
+        scrubbed, changed, err := scrubConfigSecrets(data)
+        if err != nil {
+            return fmt.Errorf("%s: %s", backup, err)
+        }
+        if !changed {
+            continue
+        }
+        // Written to a new file as the backup may be a hard link
+        if err := writeConfigFile(backup, scrubbed, 0644, 0); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Remove secrets from a config file, either the flat keys of version 1 or
+// the options of later versions. Returns true if any secret was removed.
+func scrubConfigSecrets(data []byte) ([]byte, bool, error) {
+    scrub := func(m map[string]json.RawMessage) bool {
+        changed := false
+        for k := range m {
+            if IsSecret(k) {
+                delete(m, k)
+                changed = true
+            }
+        }
+        return changed
+    }
+
+    conf := make(map[string]json.RawMessage)
+    if err := json.Unmarshal(data, &conf); err != nil {
+        return nil, false, err
+    }
+    changed := scrub(conf)
+
+    if b, ok := conf["options"]; ok {
+        options := make(map[string]json.RawMessage)
+        if err := json.Unmarshal(b, &options); err != nil {
+            return nil, false, err
+        }
+        if scrub(options) {
+            b, err := json.Marshal(options)
+            if err != nil {
+                return nil, false, err
+            }
+            conf["options"] = b
+            changed = true
+        }
+    }
+
+    if !changed {
+        return data, false, nil
+    }
+    b, err := json.Marshal(conf)
+    return b, true, err
+}
+
+// Return the number of backups to keep from config-backups
+func configBackups(conf map[string]interface{}) int {
+    v, ok := conf["config-backups"].(string)
+    if !ok {
+        return ConfigDefaultBackups
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil || n < 0 {
+        return ConfigDefaultBackups
+    }
+    return n
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+    "log"
+)
+
+// Locking of the config file is not supported on this platform
+func LockConfigFile(filename string) error {
+    log.Println("Warning: locking of config files is not supported, make sure only one process uses", filename)
+    return nil
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strconv"
+    "strings"
+    "syscall"
+)
+
+// Take an exclusive lock on <file>.lock so that only one process uses a
+// config file at a time. The lock is held until the process exits, the file
+// contains the pid of the holder.
+func LockConfigFile(filename string) error {
+    lockfile := filename + ".lock"
+
+    f, err := os.OpenFile(lockfile, os.O_RDWR|os.O_CREATE, 0644)
+    if err != nil {
+        return err
+    }
+
+    if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+        f.Close()
+        if err == syscall.EWOULDBLOCK {
+            pid, _ := ioutil.ReadFile(lockfile)
+            return fmt.Errorf("config %s is in use by another process (pid %s)", filename, strings.TrimSpace(string(pid)))
+        }
+        return fmt.Errorf("lock %s: %s", lockfile, err)
+    }
+
+    f.Truncate(0)
+    f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+    f.Sync()
+
+    // Keep the file open, closing it would release the lock
+    configLockFile = f
+
+    return nil
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+// The version of the config file format. Files without a version are the
+// flat keys of version 1, they are migrated when loaded.
+const ConfigVersion = 2
+
+// The configuration of a group, each field is stored in the config under the
+// key in its conf tag followed by the FQDN of the group
+type GroupConfig struct {
+    Parent                 string   `json:"parent" conf:"parent:"`
+    Signers                []string `json:"signers" conf:"signers:"`
+    Ttl                    string   `json:"ttl,omitempty" conf:"group-ttl:"`
+    Model                  string   `json:"model,omitempty" conf:"group-model:"`
+    KskSigner              string   `json:"ksk_signer,omitempty" conf:"group-ksk-signer:"`
+    RolloverDetect         string   `json:"rollover_detect,omitempty" conf:"group-rollover-detect:"`
+    DnskeysSynced          string   `json:"dnskeys_synced,omitempty" conf:"group-dnskeys-synced:"`
+    CdscdnskeysSynced      string   `json:"cdscdnskeys_synced,omitempty" conf:"group-cdscdnskeys-synced:"`
+    NsesSynced             string   `json:"nses_synced,omitempty" conf:"group-nses-synced:"`
+    ParentDsSynced         string   `json:"parent_ds_synced,omitempty" conf:"group-parent-ds-synced:"`
+    ParentNsSynced         string   `json:"parent_ns_synced,omitempty" conf:"group-parent-ns-synced:"`
+    WaitDs                 string   `json:"wait_ds,omitempty" conf:"group-wait-ds:"`
+    WaitNs                 string   `json:"wait_ns,omitempty" conf:"group-wait-ns:"`
+    WaitDnskey             string   `json:"wait_dnskey,omitempty" conf:"group-wait-dnskey:"`
+    WaitZsk                string   `json:"wait_zsk,omitempty" conf:"group-wait-zsk:"`
+    AutomateInterval       string   `json:"automate_interval,omitempty" conf:"group-automate-interval:"`
+    AutomateParentInterval string   `json:"automate_parent_interval,omitempty" conf:"group-automate-parent-interval:"`
+    Stage                  string   `json:"stage,omitempty" conf:"automate-stage:"`
+    StageEntered           string   `json:"stage_entered,omitempty" conf:"automate-stage-entered:"`
+    Error                  string   `json:"error,omitempty" conf:"automate-error:"`
+    ErrorStage             string   `json:"error_stage,omitempty" conf:"automate-error-stage:"`
+}
+
+// The configuration of a signer, stored the same way as GroupConfig with the
+// name of the signer
+type SignerConfig struct {
+    Address   string `json:"address" conf:"signer:"`
+    Group     string `json:"group" conf:"signer-group:"`
+    NS        string `json:"ns" conf:"signer-ns:"`
+    Type      string `json:"type,omitempty" conf:"signer-type:"`
+    Tsigkey   string `json:"tsigkey,omitempty" conf:"signer-tsigkey:"`
+    Desec     string `json:"desec,omitempty" conf:"signer-desec:"`
+    QueryTsig string `json:"query_tsig,omitempty" conf:"signer-query-tsig:"`
+    Leaving   string `json:"leaving,omitempty" conf:"signer-leaving:"`
+}
+
+// Options that are lists, all other options are strings
+var configListOptions = map[string]bool{
+    "groups":             true,
+    "automate-autostart": true,
+}
+
+// Check if a key is a list
+func configIsList(key string) bool {
+    return configListOptions[key] || strings.HasPrefix(key, "signers:")
+}
+
+// The config file, groups and signers are typed and all other keys are kept
+// as options
+type configFile struct {
+    Version int                      `json:"version"`
+    Groups  map[string]*GroupConfig  `json:"groups"`
+    Signers map[string]*SignerConfig `json:"signers"`
+    Options map[string]interface{}   `json:"options"`
+}
+
+// Fill a GroupConfig or SignerConfig from the flat keys, returns the keys
+// used
+func configFromKeys(v interface{}, id string, conf map[string]interface{}) ([]string, error) {
+    used := []string{}
+    s := reflect.ValueOf(v).Elem()
+    t := s.Type()
+    for i := 0; i < t.NumField(); i++ {
+        key := t.Field(i).Tag.Get("conf") + id
+        value, ok := conf[key]
+        if !ok {
+            continue
+        }
+        used = append(used, key)
+
+        switch f := s.Field(i); f.Kind() {
+        case reflect.String:
+            str, ok := value.(string)
+            if !ok {
+                return nil, fmt.Errorf("%s is not a string", key)
+            }
+            f.SetString(str)
+        case reflect.Slice:
+            list, ok := value.([]string)
+            if !ok {
+                return nil, fmt.Errorf("%s is not a list", key)
+            }
+            f.Set(reflect.ValueOf(append([]string{}, list...)))
+        }
+    }
+    return used, nil
+}
+
+// Add the fields of a GroupConfig or SignerConfig as flat keys to conf
+func configToKeys(v interface{}, id string, conf map[string]interface{}) {
+    s := reflect.ValueOf(v).Elem()
+    t := s.Type()
+    for i := 0; i < t.NumField(); i++ {
+        key := t.Field(i).Tag.Get("conf") + id
+        switch f := s.Field(i); f.Kind() {
+        case reflect.String:
+            if f.String() != "" {
+                conf[key] = f.String()
+            }
+        case reflect.Slice:
+            if !f.IsNil() {
+                conf[key] = append([]string{}, f.Interface().([]string)...)
+            }
+        }
+    }
+}
+
+// Convert flat keys to the config file, keys that do not belong to an
+// existing group or signer are kept as options
+func configToFile(conf map[string]interface{}) (*configFile, error) {
+    rest := make(map[string]interface{})
+    for k, v := range conf {
+        rest[k] = v
+    }
+    use := func(v interface{}, id string) error {
+        used, err := configFromKeys(v, id, conf)
+        for _, k := range used {
+            delete(rest, k)
+        }
+        return err
+    }
+
+    f := &configFile{
+        Version: ConfigVersion,
+        Groups:  make(map[string]*GroupConfig),
+        Signers: make(map[string]*SignerConfig),
+        Options: rest,
+    }
+
+    groups, ok := conf["groups"]
+    if ok {
+        if _, ok := groups.([]string); !ok {
+            return nil, fmt.Errorf("groups is not a list")
+        }
+        for _, fqdn := range groups.([]string) {
+            g := &GroupConfig{}
+            if err := use(g, fqdn); err != nil {
+                return nil, err
+            }
+            f.Groups[fqdn] = g
+        }
+        delete(rest, "groups")
+    }
+
+    for k := range conf {
+        if !strings.HasPrefix(k, "signer:") {
+            continue
+        }
+        name := strings.TrimPrefix(k, "signer:")
+        s := &SignerConfig{}
+        if err := use(s, name); err != nil {
+            return nil, err
+        }
+        f.Signers[name] = s
+    }
+
+    return f, nil
+}
+
+// Convert the config file to flat keys
+func configFromFile(f *configFile) map[string]interface{} {
+    conf := make(map[string]interface{})
+    for k, v := range f.Options {
+        conf[k] = v
+    }
+
+    groups := []string{}
+    for fqdn, g := range f.Groups {
+        groups = append(groups, fqdn)
+        configToKeys(g, fqdn, conf)
+    }
+    if len(groups) > 0 {
+        sort.Strings(groups)
+        conf["groups"] = groups
+    }
+
+    for name, s := range f.Signers {
+        configToKeys(s, name, conf)
+    }
+
+    return conf
+}
+
+// Check that an address is <host|ip>:<port>
+func configValidAddress(address string) bool {
+    i := strings.LastIndexByte(address, ':')
+    if i < 1 {
+        return false
+    }
+    _, err := strconv.ParseUint(address[i+1:], 10, 16)
+    return err == nil
+}
+
+func configValidTime(value string) bool {
+    _, err := time.Parse(time.RFC3339, value)
+    return value == "" || err == nil
+}
+
+// Validate the config file, returns all problems found with the values of
+// groups and signers. Problems that keep the config from being used, options
+// of the wrong type, are returned as error.
+func (f *configFile) Validate() ([]string, error) {
+    problems := []string{}
+    for fqdn := range f.Groups {
+        problems = append(problems, f.groupProblems(fqdn)...)
+    }
+    for name := range f.Signers {
+        problems = append(problems, f.signerProblems(name)...)
+    }
+
+    invalid := []string{}
+    for k, v := range f.Options {
+        if p := configTypeProblem(k, v); p != "" {
+            invalid = append(invalid, p)
+        }
+    }
+
+    sort.Strings(problems)
+    if len(invalid) > 0 {
+        sort.Strings(invalid)
+        return problems, fmt.Errorf("invalid config: %s", strings.Join(invalid, ", "))
+    }
+    return problems, nil
+}
+
+// Return the problems of a group, the signers it refers to must be in the
+// config file
+func (f *configFile) groupProblems(fqdn string) []string {
+    problems := []string{}
+    problem := func(format string, a ...interface{}) {
+        problems = append(problems, fmt.Sprintf(format, a...))
+    }
+    g := f.Groups[fqdn]
+
+    if _, ok := dns.IsDomainName(fqdn); !ok {
+        problem("group %s: invalid name", fqdn)
+    }
+    if !configValidAddress(g.Parent) {
+        problem("group %s: invalid parent address %q", fqdn, g.Parent)
+    }
+    for _, name := range g.Signers {
+        if s, ok := f.Signers[name]; !ok {
+            problem("group %s: signer %s does not exist", fqdn, name)
+        } else if s.Group != fqdn {
+            problem("group %s: signer %s is in group %s", fqdn, name, s.Group)
+        }
+    }
+    if g.Ttl != "" {
+        if _, err := strconv.ParseUint(g.Ttl, 10, 32); err != nil {
+            problem("group %s: invalid TTL %s", fqdn, g.Ttl)
+        }
+    }
+    switch g.Model {
+    case "", "2":
+    case "1":
+        if f.Signers[g.KskSigner] == nil || f.Signers[g.KskSigner].Group != fqdn {
+            problem("group %s: KSK signer %s is not in the group", fqdn, g.KskSigner)
+        }
+    default:
+        problem("group %s: invalid model %s", fqdn, g.Model)
+    }
+    if g.Stage != "" && GetAutomateStage(g.Stage) == nil {
+        problem("group %s: unknown automation stage %s", fqdn, g.Stage)
+    }
+    for _, t := range []string{g.WaitDs, g.WaitNs, g.WaitDnskey, g.WaitZsk, g.StageEntered} {
+        if !configValidTime(t) {
+            problem("group %s: invalid time %s", fqdn, t)
+        }
+    }
+
+    return problems
+}
+
+// Return the problems of a signer, its group must be in the config file
+func (f *configFile) signerProblems(name string) []string {
+    problems := []string{}
+    problem := func(format string, a ...interface{}) {
+        problems = append(problems, fmt.Sprintf(format, a...))
+    }
+    s := f.Signers[name]
+
+    if !configValidAddress(s.Address) {
+        problem("signer %s: invalid address %q", name, s.Address)
+    }
+    if g, ok := f.Groups[s.Group]; !ok {
+        problem("signer %s: group %s does not exist", name, s.Group)
+    } else if !configContains(g.Signers, name) {
+        problem("signer %s: not listed in group %s", name, s.Group)
+    }
+    if _, ok := dns.IsDomainName(s.NS); !ok || s.NS == "" {
+        problem("signer %s: invalid NS %q", name, s.NS)
+    }
+    if s.Type != "" && Updaters[s.Type] == nil {
+        problem("signer %s: unknown type %s", name, s.Type)
+    }
+
+    return problems
+}
+
+// Return the problem with the type of a key, empty if there is none
+func configTypeProblem(key string, value interface{}) string {
+    switch value.(type) {
+    case string:
+        if configIsList(key) {
+            return key + " is not a list"
+        }
+    case []string:
+        if !configIsList(key) {
+            return key + " is not a string"
+        }
+    default:
+        return fmt.Sprintf("%s has invalid type %T", key, value)
+    }
+    return ""
+}
+
+// Return the problems of the flat keys of a config, see Validate
+func configProblems(conf map[string]interface{}) ([]string, error) {
+    f, err := configToFile(conf)
+    if err != nil {
+        return nil, err
+    }
+    return f.Validate()
+}
+
+// The key prefixes of the fields of GroupConfig and SignerConfig
+var configGroupPrefixes = configPrefixes(&GroupConfig{})
+var configSignerPrefixes = configPrefixes(&SignerConfig{})
+
+func configPrefixes(v interface{}) []string {
+    prefixes := []string{}
+    t := reflect.TypeOf(v).Elem()
+    for i := 0; i < t.NumField(); i++ {
+        prefixes = append(prefixes, t.Field(i).Tag.Get("conf"))
+    }
+    return prefixes
+}
+
+// Return the groups and signers that keys belong to, keys of groups and
+// signers that do not exist are options. The signers listed in a group and
+// the group of a signer are returned for those keys.
+func configOwners(conf map[string]interface{}, keys []string) ([]string, []string) {
+    groups := []string{}
+    signers := []string{}
+    all, _ := conf["groups"].([]string)
+    for _, key := range keys {
+        if key == "groups" {
+            groups = append(groups, all...)
+            continue
+        }
+        for _, prefix := range configGroupPrefixes {
+            if fqdn := strings.TrimPrefix(key, prefix); fqdn != key && configContains(all, fqdn) {
+                groups = append(groups, fqdn)
+            }
+        }
+        if list, ok := conf[key].([]string); ok && strings.HasPrefix(key, "signers:") {
+            signers = append(signers, list...)
+        }
+        if fqdn, ok := conf[key].(string); ok && strings.HasPrefix(key, "signer-group:") {
+            groups = append(groups, fqdn)
+        }
+        for _, prefix := range configSignerPrefixes {
+            if name := strings.TrimPrefix(key, prefix); name != key {
+                if _, ok := conf["signer:"+name]; ok {
+                    signers = append(signers, name)
+                }
+            }
+        }
+    }
+    return groups, signers
+}
+
+// Return the problems of groups and signers, only those and the ones they
+// refer to are read from the config
+func configOwnerProblems(conf map[string]interface{}, groups, signers []string) ([]string, error) {
+    f := &configFile{
+        Groups:  make(map[string]*GroupConfig),
+        Signers: make(map[string]*SignerConfig),
+    }
+    all, _ := conf["groups"].([]string)
+    addGroup := func(fqdn string) error {
+        if _, ok := f.Groups[fqdn]; ok || !configContains(all, fqdn) {
+            return nil
+        }
+        g := &GroupConfig{}
+        f.Groups[fqdn] = g
+        _, err := configFromKeys(g, fqdn, conf)
+        return err
+    }
+    addSigner := func(name string) error {
+        if _, ok := f.Signers[name]; ok {
+            return nil
+        }
+        if _, ok := conf["signer:"+name]; !ok {
+            return nil
+        }
+        s := &SignerConfig{}
+        f.Signers[name] = s
+        _, err := configFromKeys(s, name, conf)
+        return err
+    }
+
+    for _, fqdn := range groups {
+        if err := addGroup(fqdn); err != nil {
+            return nil, err
+        }
+        g, ok := f.Groups[fqdn]
+        if !ok {
+            continue
+        }
+        for _, name := range append([]string{g.KskSigner}, g.Signers...) {
+            if err := addSigner(name); err != nil {
+                return nil, err
+            }
+        }
+    }
+    for _, name := range signers {
+        if err := addSigner(name); err != nil {
+            return nil, err
+        }
+        if s, ok := f.Signers[name]; ok {
+            if err := addGroup(s.Group); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    problems := []string{}
+    seen := make(map[string]bool)
+    for _, fqdn := range groups {
+        if _, ok := f.Groups[fqdn]; ok && !seen["group "+fqdn] {
+            seen["group "+fqdn] = true
+            problems = append(problems, f.groupProblems(fqdn)...)
+        }
+    }
+    for _, name := range signers {
+        if _, ok := f.Signers[name]; ok && !seen["signer "+name] {
+            seen["signer "+name] = true
+            problems = append(problems, f.signerProblems(name)...)
+        }
+    }
+    sort.Strings(problems)
+    return problems, nil
+}
+
+// Check the type of keys, returns an error listing the keys of the wrong
+// type
+func configCheckTypes(conf map[string]interface{}, keys []string) error {
+    invalid := []string{}
+    for _, k := range keys {
+        if v, ok := conf[k]; ok {
+            if p := configTypeProblem(k, v); p != "" {
+                invalid = append(invalid, p)
+            }
+        }
+    }
+    if len(invalid) > 0 {
+        sort.Strings(invalid)
+        return fmt.Errorf("invalid config: %s", strings.Join(invalid, ", "))
+    }
+    return nil
+}
+
+// Validate a loaded config, problems that keep the config from being used
+// are returned as error and other problems are logged as warnings, so that
+// the config can still be loaded and fixed with conf-set
+func configValidate(conf map[string]interface{}, name string) error {
+    problems, err := configProblems(conf)
+    if err != nil {
+        return err
+    }
+    for _, p := range problems {
+        log.Printf("Warning: %s: %s", name, p)
+    }
+    return nil
+}
+
+func configContains(list []string, value string) bool {
+    for _, v := range list {
+        if v == value {
+            return true
+        }
+    }
+    return false
+}
+
+// Convert the lists of a decoded JSON object to []string
+func configLists(conf map[string]interface{}) error {
+    for k, v := range conf {
+        switch l := v.(type) {
+        case string:
+        case []interface{}:
+            n := []string{}
+            for _, e := range l {
+                s, ok := e.(string)
+                if !ok {
+                    return fmt.Errorf("list %s has entry that is not a string (%T)", k, e)
+                }
+                n = append(n, s)
+            }
+            conf[k] = n
+        default:
+            return fmt.Errorf("%s is not a string or list (%T)", k, v)
+        }
+    }
+    return nil
+}
+
+// Decode a config file, returns the flat keys and if the file was of an
+// older version. The config is not validated, see configValidate.
+func configDecode(b []byte) (map[string]interface{}, bool, error) {
+    probe := make(map[string]json.RawMessage)
+    if err := json.Unmarshal(b, &probe); err != nil {
+        return nil, false, err
+    }
+
+    var version int
+    if v, ok := probe["version"]; !ok || json.Unmarshal(v, &version) != nil {
+        // Version 1, all keys at the top level
+        conf := make(map[string]interface{})
+        if err := json.Unmarshal(b, &conf); err != nil {
+            return nil, false, err
+        }
+        if err := configLists(conf); err != nil {
+            return nil, false, err
+        }
+        if _, err := configToFile(conf); err != nil {
+            return nil, false, err
+        }
+        return conf, true, nil
+    }
+
+    if version > ConfigVersion {
+        return nil, false, fmt.Errorf("config version %d is newer than supported version %d", version, ConfigVersion)
+    }
+
+    f := &configFile{}
+    if err := json.Unmarshal(b, f); err != nil {
+        return nil, false, err
+    }
+    if f.Options == nil {
+        f.Options = make(map[string]interface{})
+    }
+    if err := configLists(f.Options); err != nil {
+        return nil, false, err
+    }
+    for fqdn, g := range f.Groups {
+        if g == nil {
+            return nil, false, fmt.Errorf("group %s is empty", fqdn)
+        }
+    }
+    for name, s := range f.Signers {
+        if s == nil {
+            return nil, false, fmt.Errorf("signer %s is empty", name)
+        }
+    }
+
+    return configFromFile(f), version < ConfigVersion, nil
+}
+
+// Return the configuration of a group
+func (c *config) Group(fqdn string) (*GroupConfig, error) {
+    c.m.RLock()
+    defer c.m.RUnlock()
+
+    if !configContains(c.listGet("groups"), fqdn) {
+        return nil, fmt.Errorf("group %s does not exist", fqdn)
+    }
+
+    g := &GroupConfig{}
+    if _, err := configFromKeys(g, fqdn, c.conf); err != nil {
+        return nil, err
+    }
+    return g, nil
+}
+
+// Return the configuration of a signer
+func (c *config) Signer(name string) (*SignerConfig, error) {
+    c.m.RLock()
+    defer c.m.RUnlock()
+
+    if _, ok := c.conf["signer:"+name]; !ok {
+        return nil, fmt.Errorf("signer %s does not exist", name)
+    }
+
+    s := &SignerConfig{}
+    if _, err := configFromKeys(s, name, c.conf); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// A version 1 config with a group of two signers
+const configTestV1 = `{
+    "groups": ["example.com."],
+    "parent:example.com.": "192.0.2.1:53",
+    "signers:example.com.": ["s1", "s2"],
+    "group-ttl:example.com.": "3600",
+    "automate-stage:example.com.": "ready",
+    "automate-stage-entered:example.com.": "2026-01-02T03:04:05Z",
+    "signer:s1": "192.0.2.2:53",
+    "signer-group:s1": "example.com.",
+    "signer-ns:s1": "ns1.example.net.",
+    "signer-type:s1": "nsupdate",
+    "signer-tsigkey:s1": "k1",
+    "signer:s2": "192.0.2.3:53",
+    "signer-group:s2": "example.com.",
+    "signer-ns:s2": "ns2.example.net.",
+    "automate-autostart": ["example.com."],
+    "sig-min-validity": "24h"
+}`
+
+func configTestDecode(t *testing.T, b []byte) map[string]interface{} {
+    conf := make(map[string]interface{})
+    if err := json.Unmarshal(b, &conf); err != nil {
+        t.Fatal(err)
+    }
+    if err := configLists(conf); err != nil {
+        t.Fatal(err)
+    }
+    return conf
+}
+
+func TestConfigMigrate(t *testing.T) {
+    v1 := configTestDecode(t, []byte(configTestV1))
+
+    conf, migrated, err := configDecode([]byte(configTestV1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !migrated {
+        t.Error("version 1 config not reported as migrated")
+    }
+    if !reflect.DeepEqual(conf, v1) {
+        t.Errorf("migrated config %v, expected %v", conf, v1)
+    }
+
+    // version 2
+    f, err := configToFile(conf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if problems, err := f.Validate(); err != nil || len(problems) > 0 {
+        t.Fatalf("valid config has problems %v, %v", problems, err)
+    }
+    if len(f.Groups) != 1 || len(f.Signers) != 2 {
+        t.Fatalf("expected 1 group and 2 signers, got %d and %d", len(f.Groups), len(f.Signers))
+    }
+    if g := f.Groups["example.com."]; g.Ttl != "3600" || !reflect.DeepEqual(g.Signers, []string{"s1", "s2"}) {
+        t.Errorf("unexpected group %+v", g)
+    }
+    if s := f.Signers["s1"]; s.NS != "ns1.example.net." || s.Tsigkey != "k1" {
+        t.Errorf("unexpected signer %+v", s)
+    }
+    if len(f.Options) != 2 {
+        t.Errorf("expected 2 options, got %v", f.Options)
+    }
+
+    b, err := json.Marshal(f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    conf, migrated, err = configDecode(b)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if migrated {
+        t.Error("version 2 config reported as migrated")
+    }
+
+    // and back to version 1
+    b, err = json.Marshal(conf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if back := configTestDecode(t, b); !reflect.DeepEqual(back, v1) {
+        t.Errorf("config after round trip %v, expected %v", back, v1)
+    }
+}
+
+func TestConfigDecodeErrors(t *testing.T) {
+    tests := map[string]string{
+        "newer version":       `{"version": 3}`,
+        "empty group":         `{"version": 2, "groups": {"example.com.": null}}`,
+        "empty signer":        `{"version": 2, "signers": {"s1": null}}`,
+        "list of non-strings": `{"version": 2, "options": {"groups": [1]}}`,
+        "groups not a list":   `{"groups": "example.com."}`,
+        "signers not a list":  `{"groups": ["example.com."], "signers:example.com.": "s1"}`,
+        "invalid json":        `{"groups": `,
+    }
+
+    for name, config := range tests {
+        if _, _, err := configDecode([]byte(config)); err == nil {
+            t.Errorf("%s: decoded without error", name)
+        }
+    }
+}
+
+func TestConfigValidate(t *testing.T) {
+    tests := []struct {
+        name    string
+        set     map[string]interface{}
+        problem string
+        invalid bool
+    }{
+        {"invalid group", map[string]interface{}{"groups": []string{"example.com.", "bad..name."}}, "group bad..name.: invalid name", false},
+        {"invalid parent", map[string]interface{}{"parent:example.com.": "192.0.2.1"}, "invalid parent address", false},
+        {"missing signer", map[string]interface{}{"signers:example.com.": []string{"s1", "s2", "s3"}}, "signer s3 does not exist", false},
+        {"signer in other group", map[string]interface{}{"signer-group:s2": "example.net."}, "signer s2 is in group example.net.", false},
+        {"signer not listed", map[string]interface{}{"signers:example.com.": []string{"s1"}}, "signer s2: not listed in group", false},
+        {"invalid TTL", map[string]interface{}{"group-ttl:example.com.": "3600s"}, "invalid TTL 3600s", false},
+        {"invalid model", map[string]interface{}{"group-model:example.com.": "3"}, "invalid model 3", false},
+        {"KSK signer", map[string]interface{}{"group-model:example.com.": "1", "group-ksk-signer:example.com.": "s3"}, "KSK signer s3 is not in the group", false},
+        {"unknown stage", map[string]interface{}{"automate-stage:example.com.": "bogus"}, "unknown automation stage bogus", false},
+        {"invalid time", map[string]interface{}{"group-wait-ds:example.com.": "tomorrow"}, "invalid time tomorrow", false},
+        {"invalid address", map[string]interface{}{"signer:s1": "192.0.2.2"}, "signer s1: invalid address", false},
+        {"invalid NS", map[string]interface{}{"signer-ns:s1": ""}, "signer s1: invalid NS", false},
+        {"unknown type", map[string]interface{}{"signer-type:s1": "bogus"}, "unknown type bogus", false},
+        {"option not a list", map[string]interface{}{"automate-autostart": "example.com."}, "automate-autostart is not a list", true},
+        {"option not a string", map[string]interface{}{"sig-min-validity": []string{"24h"}}, "sig-min-validity is not a string", true},
+    }
+
+    for _, test := range tests {
+        conf := configTestDecode(t, []byte(configTestV1))
+        keys := []string{}
+        for k := range test.set {
+            keys = append(keys, k)
+        }
+        // the owners of the changed keys before and after, as CheckSet
+        groups, signers := configOwners(conf, keys)
+        for k, v := range test.set {
+            conf[k] = v
+        }
+        g, s := configOwners(conf, keys)
+        groups, signers = append(groups, g...), append(signers, s...)
+
+        problems, err := configProblems(conf)
+        partial, perr := configOwnerProblems(conf, groups, signers)
+        if perr == nil {
+            perr = configCheckTypes(conf, keys)
+        }
+        if test.invalid {
+            if perr == nil || !strings.Contains(perr.Error(), test.problem) {
+                t.Errorf("%s: expected error %q for the changed keys, got %v", test.name, test.problem, perr)
+            }
+            if err == nil || !strings.Contains(err.Error(), test.problem) {
+                t.Errorf("%s: expected error %q, got %v", test.name, test.problem, err)
+            }
+            if err := configValidate(conf, test.name); err == nil {
+                t.Errorf("%s: configValidate returned no error", test.name)
+            }
+            continue
+        }
+
+        if err != nil {
+            t.Errorf("%s: unexpected error %s", test.name, err)
+            continue
+        }
+        if !strings.Contains(strings.Join(problems, "\n"), test.problem) {
+            t.Errorf("%s: expected problem %q, got %v", test.name, test.problem, problems)
+        }
+        if perr != nil || !strings.Contains(strings.Join(partial, "\n"), test.problem) {
+            t.Errorf("%s: expected problem %q for the changed keys, got %v, %v", test.name, test.problem, partial, perr)
+        }
+        // loaded and stored with a warning
+        if err := configValidate(conf, test.name); err != nil {
+            t.Errorf("%s: configValidate returned %s", test.name, err)
+        }
+    }
+}
+
+func TestConfigCheckSet(t *testing.T) {
+    conf, _, err := configDecode([]byte(configTestV1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    previous := Config
+    t.Cleanup(func() { Config = previous })
+    Config = NewConfig()
+    for k, v := range conf {
+        Config.conf[k] = v
+    }
+
+    if err := Config.CheckSet("group-ttl:example.com.", "600"); err != nil {
+        t.Error(err)
+    }
+    if err := Config.CheckSet("group-ttl:example.com.", "600s"); err == nil {
+        t.Error("invalid TTL not refused")
+    }
+    if err := Config.CheckSet("automate-stage:example.com.", "bogus"); err == nil {
+        t.Error("unknown stage not refused")
+    }
+    if err := Config.CheckSet("parent:example.com.", "192.0.2.1"); err == nil {
+        t.Error("invalid parent not refused")
+    }
+
+    // problems the config already has do not refuse other changes
+    Config.conf["group-model:example.com."] = "3"
+    if err := Config.CheckSet("group-ttl:example.com.", "600"); err != nil {
+        t.Errorf("refused because of an existing problem: %s", err)
+    }
+    if err := Config.CheckSet("group-model:example.com.", "2"); err != nil {
+        t.Errorf("refused fixing a problem: %s", err)
+    }
+
+    // only the group or signer of the key is validated
+    Config.conf["groups"] = []string{"example.com.", "example.net."}
+    Config.conf["parent:example.net."] = "invalid"
+    if err := Config.CheckSet("group-ttl:example.com.", "60"); err != nil {
+        t.Errorf("refused because of another group: %s", err)
+    }
+    if err := Config.CheckSet("signer-ns:s1", "ns.example.org."); err != nil {
+        t.Errorf("refused valid signer change: %s", err)
+    }
+    if err := Config.CheckSet("signer-group:s1", "example.net."); err == nil {
+        t.Error("moving a signer to a group it is not listed in not refused")
+    }
+    if v := Config.Get("group-ttl:example.com.", ""); v != "3600" {
+        t.Errorf("CheckSet changed the value to %s", v)
+    }
+}
+
+// A storage that keeps the changed keys of the last Store
+type configTestStorage struct {
+    changed []string
+}
+
+func (s *configTestStorage) Load() (map[string]interface{}, bool, error) {
+    return make(map[string]interface{}), false, nil
+}
+
+func (s *configTestStorage) Store(conf map[string]interface{}, changed []string) error {
+    s.changed = changed
+    return nil
+}
+
+func (s *configTestStorage) Close() error {
+    return nil
+}
+
+func TestConfigStore(t *testing.T) {
+    conf, _, err := configDecode([]byte(configTestV1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    c := NewConfig()
+    storage := &configTestStorage{}
+    c.storage = storage
+    for k, v := range conf {
+        c.conf[k] = v
+    }
+
+    // problems of keys that are not changed do not keep the changes from
+    // being stored
+    c.conf["parent:example.com."] = "invalid"
+    c.Set("group-ttl:example.com.", "600")
+    if err := c.Store(); err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(storage.changed, []string{"group-ttl:example.com."}) {
+        t.Errorf("stored changes %v, expected the TTL", storage.changed)
+    }
+
+    storage.changed = nil
+    c.conf["automate-autostart"] = "example.com."
+    c.dirty["automate-autostart"] = true
+    if err := c.Store(); err == nil {
+        t.Error("option of the wrong type stored")
+    }
+    if storage.changed != nil {
+        t.Errorf("stored changes %v of an invalid config", storage.changed)
+    }
+}
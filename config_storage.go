@@ -0,0 +1,92 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+)
+
+// The storage of the config, see config.Open
+type ConfigStorage interface {
+    // Load all keys, returns true if the storage has an older version of
+    // the config that needs to be migrated
+    Load() (map[string]interface{}, bool, error)
+    // Write the config, changed are the keys set or removed (no longer in
+    // conf) since the last Store. Either all changes are written or none.
+    Store(conf map[string]interface{}, changed []string) error
+    Close() error
+}
+
+// A storage that keeps previous versions of the config, they may have secrets
+// from before the secrets were moved to the secret provider
+type configSecretScrubber interface {
+    // Remove secrets from the previous versions of the config
+    ScrubSecrets() error
+}
+
+// The storages that can be selected with -storage
+var ConfigStorages = map[string]func(filename string) (ConfigStorage, error){
+    "json": NewJsonStorage,
+    "bolt": NewBoltStorage,
+}
+
+// Open a storage by name
+func OpenConfigStorage(name, filename string) (ConfigStorage, error) {
+    open, ok := ConfigStorages[name]
+    if !ok {
+        return nil, fmt.Errorf("Unknown storage %s", name)
+    }
+    return open(filename)
+}
+
+// The config as one JSON file that is rewritten in full on each Store, see
+// writeConfigFile
+type jsonStorage struct {
+    filename string
+}
+
+func NewJsonStorage(filename string) (ConfigStorage, error) {
+    return &jsonStorage{filename: filename}, nil
+}
+
+func (s *jsonStorage) Load() (map[string]interface{}, bool, error) {
+    b, err := ioutil.ReadFile(s.filename)
+    if os.IsNotExist(err) {
+        return make(map[string]interface{}), false, nil
+    }
+    if err != nil {
+        return nil, false, err
+    }
+
+    conf, migrated, err := configDecode(b)
+    if err != nil {
+        return nil, false, fmt.Errorf("%s: %s", s.filename, err)
+    }
+    if err := configValidate(conf, s.filename); err != nil {
+        return nil, false, fmt.Errorf("%s: %s", s.filename, err)
+    }
+    return conf, migrated, nil
+}
+
+func (s *jsonStorage) Store(conf map[string]interface{}, changed []string) error {
+    f, err := configToFile(conf)
+    if err != nil {
+        return err
+    }
+
+    b, err := json.Marshal(f)
+    if err != nil {
+        return err
+    }
+
+    return writeConfigFile(s.filename, b, 0644, configBackups(conf))
+}
+
+func (s *jsonStorage) ScrubSecrets() error {
+    return scrubConfigBackups(s.filename)
+}
+
+func (s *jsonStorage) Close() error {
+    return nil
+}
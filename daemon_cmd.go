@@ -1,49 +1,92 @@
 package main
 
 import (
+    "crypto/tls"
     "fmt"
     "log"
     "net"
     "net/http"
     "net/rpc"
     "strings"
-    "sync"
 )
 
 var IsDaemon bool
-var DaemonConf string
-var DaemonLock sync.Mutex
 
+// The RPC service, one is created for each connection with the identity of
+// the caller (see DaemonIdentity)
 type Rpc struct {
+    Identity string
+    Address  string
 }
 
 func (r *Rpc) Call(args []string, reply *[]string) error {
-    // lock the daemon so only one command is executed at the same time
-    DaemonLock.Lock()
-    defer DaemonLock.Unlock()
+    result, err := r.call(args)
+    if err != nil {
+        return err
+    }
+    *reply = result.Output
+    return nil
+}
 
-    cmd, ok := Command[args[0]]
-    if !ok {
-        log.Println("Invalid call:", args[0])
-        return fmt.Errorf("Command does not exist: ", args[0])
+// Same as Call but returns the full result of the command, including the
+// structured data for JSON output. The result is also returned if the
+// command fails, with the error set, as net/rpc drops the reply of a call
+// that returns an error.
+func (r *Rpc) CallResult(args []string, reply *CmdResult) error {
+    result, err := r.call(args)
+    if result == nil {
+        return err
+    }
+    *reply = *result
+    if err != nil {
+        reply.Error = err.Error()
     }
+    return nil
+}
 
-    log.Println("Calling command", args)
-    WsConsole("Calling command " + strings.Join(args, " "))
-    if err := cmd(args[1:], true, reply); err != nil {
-        WsConsole("Command " + args[0] + " error: " + err.Error())
-        return fmt.Errorf("Command ", args[0], " error: ", err)
+// Run a command as the caller, the result is also returned if the command
+// ran but failed
+func (r *Rpc) call(args []string) (*CmdResult, error) {
+    if r.Identity == "" {
+        return nil, fmt.Errorf("Not authenticated")
+    }
+    if len(args) < 1 {
+        return nil, fmt.Errorf("No command given")
     }
-    for _, r := range *reply {
+    // secrets given as arguments must not end up in the log or on the console
+    logArgs := RedactArgs(args)
+    if err := PolicyAllowed(r.Identity, args); err != nil {
+        log.Println("Denied call", logArgs, ":", err)
+        WsConsole("Denied command " + strings.Join(logArgs, " ") + ": " + err.Error())
+        return nil, fmt.Errorf("%w: %s", ErrPermissionDenied, err)
+    }
+    if _, ok := Command[args[0]]; !ok {
+        log.Println("Invalid call:", args[0])
+        return nil, fmt.Errorf("Command does not exist: %s", args[0])
+    }
+
+    // lock the group of the command or all of the config
+    unlock := LockCommand(args)
+    end := AuditBegin(commandLockGroup(args), &AuditCaller{Identity: r.Identity, Address: r.Address, Command: args})
+    log.Println("Calling command", logArgs, "as", r.Identity)
+    WsConsole("Calling command " + strings.Join(logArgs, " ") + " as " + r.Identity)
+    result, err := RunCommand(args, true)
+    end()
+    unlock()
+    for _, r := range result.Output {
         WsConsole(" " + r)
         log.Println("", r)
     }
+    if err != nil {
+        WsConsole("Command " + args[0] + " error: " + err.Error())
+        return result, fmt.Errorf("Command %s error: %s", args[0], err)
+    }
 
-    if err := Config.Store(DaemonConf); err != nil {
+    if err := StoreConfig(); err != nil {
         log.Fatal(err)
     }
 
-    return nil
+    return result, nil
 }
 
 func init() {
@@ -62,17 +105,29 @@ func DaemonCmd(args []string, remote bool, output *[]string) error {
 
     IsDaemon = true
 
-    r := new(Rpc)
-    rpc.Register(r)
-    rpc.HandleHTTP()
+    tlsConfig, err := DaemonTlsConfig()
+    if err != nil {
+        return err
+    }
+
+    // RPC is served on its own mux so it is not reachable on the -http
+    // listener
+    mux := http.NewServeMux()
+    mux.Handle(rpc.DefaultRPCPath, rpcHandler{})
     l, e := net.Listen("tcp", args[0])
     if e != nil {
-        return fmt.Errorf("listen error:", e)
+        return fmt.Errorf("listen error: %s", e)
+    }
+    DaemonAuthWarnings(tlsConfig != nil)
+    if tlsConfig != nil {
+        l = tls.NewListener(l, tlsConfig)
+        log.Println("Listening for RPC over TLS on", l.Addr().String())
+    } else {
+        log.Println("Listening for RPC on", l.Addr().String())
     }
-    log.Println("Listening for RPC on", l.Addr().String())
     AutomateAutostart()
     // Start listening for gRPC, this won't really return
-    http.Serve(l, nil)
+    http.Serve(l, mux)
 
     return nil
 }
@@ -1,12 +1,158 @@
 package main
 
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+const DesecDefaultApi = "https://desec.io/api/v1"
+
 type DesecRRset struct {
     Created string   `json:"created,omitempty"`
     Domain  string   `json:"domain,omitempty"`
-    Subname string   `json:"subname,omitempty"`
+    Subname string   `json:"subname"`
     Name    string   `json:"name,omitempty"`
-    Type    string   `json:"type,omitempty"`
-    Records []string `json:"records,omitempty"`
+    Type    string   `json:"type"`
+    Records []string `json:"records"`
     Ttl     int      `json:"ttl,omitempty"`
     Touched string   `json:"touched,omitempty"`
 }
+
+type DesecClient struct {
+    Api    string
+    Token  string
+    Client *http.Client
+}
+
+// Create a deSEC.io API client for a signer using the token configured
+// with signer-desec:<name> and the secret in desectoken-<token>
+func NewDesecClient(signer string) (*DesecClient, error) {
+    token := Config.Get("signer-desec:"+signer, "")
+    if token == "" {
+        return nil, fmt.Errorf("Missing signer %s deSEC token", signer)
+    }
+
+    secret := GetSecret("desectoken-" + token)
+    if secret == "" {
+        return nil, fmt.Errorf("Missing deSEC token secret for %s", token)
+    }
+
+    return &DesecClient{
+        Api:    DesecApi(),
+        Token:  secret,
+        Client: &http.Client{Timeout: 30 * time.Second},
+    }, nil
+}
+
+// Return the base URL of the deSEC.io API, can be changed with desec-api
+// to test against another server
+func DesecApi() string {
+    return strings.TrimSuffix(Config.Get("desec-api", DesecDefaultApi), "/")
+}
+
+// Convert a FQDN to the domain name used by deSEC.io (no trailing dot)
+func DesecDomain(fqdn string) string {
+    return strings.TrimSuffix(fqdn, ".")
+}
+
+// Return the subname of a owner name within a zone, empty for the apex.
+// Names are compared case insensitive and returned in lower case as used by
+// deSEC.io.
+func DesecSubname(fqdn, name string) (string, error) {
+    zone := strings.ToLower(dns.Fqdn(fqdn))
+    name = strings.ToLower(dns.Fqdn(name))
+    if name == zone {
+        return "", nil
+    }
+    if zone == "." {
+        return strings.TrimSuffix(name, "."), nil
+    }
+    if !strings.HasSuffix(name, "."+zone) {
+        return "", fmt.Errorf("%s is not within zone %s", name, zone)
+    }
+    return strings.TrimSuffix(name, "."+zone), nil
+}
+
+func (d *DesecClient) do(method, url string, body interface{}, result interface{}) (int, error) {
+    var reader *bytes.Reader
+    if body != nil {
+        b, err := json.Marshal(body)
+        if err != nil {
+            return 0, err
+        }
+        reader = bytes.NewReader(b)
+    } else {
+        reader = bytes.NewReader([]byte{})
+    }
+
+    req, err := http.NewRequest(method, url, reader)
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Add("Authorization", fmt.Sprintf("Token %s", d.Token))
+    if body != nil {
+        req.Header.Add("Content-Type", "application/json")
+    }
+
+    resp, err := d.Client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    b, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return resp.StatusCode, err
+    }
+
+    if resp.StatusCode == http.StatusNotFound {
+        return resp.StatusCode, nil
+    }
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode, fmt.Errorf("deSEC %s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(b)))
+    }
+
+    if result != nil && len(b) > 0 {
+        if err := json.Unmarshal(b, result); err != nil {
+            return resp.StatusCode, err
+        }
+    }
+
+    return resp.StatusCode, nil
+}
+
+// Get a RRset, returns nil if it does not exist
+func (d *DesecClient) GetRRset(domain, subname, type_ string) (*DesecRRset, error) {
+    sub := subname
+    if sub == "" {
+        sub = "@"
+    }
+
+    rrset := &DesecRRset{}
+    status, err := d.do("GET", fmt.Sprintf("%s/domains/%s/rrsets/%s/%s/", d.Api, domain, sub, type_), nil, rrset)
+    if err != nil {
+        return nil, err
+    }
+    if status == http.StatusNotFound {
+        return nil, nil
+    }
+    return rrset, nil
+}
+
+// Bulk modify RRsets, a RRset with empty records will be deleted
+func (d *DesecClient) PatchRRsets(domain string, rrsets []*DesecRRset) error {
+    status, err := d.do("PATCH", fmt.Sprintf("%s/domains/%s/rrsets/", d.Api, domain), rrsets, nil)
+    if err != nil {
+        return err
+    }
+    if status == http.StatusNotFound {
+        return fmt.Errorf("deSEC domain %s not found", domain)
+    }
+    return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/miekg/dns"
+)
+
+func TestDesecSubname(t *testing.T) {
+    tests := []struct {
+        zone, name, subname string
+        err                 bool
+    }{
+        {"example.com.", "example.com.", "", false},
+        {"example.com.", "EXAMPLE.com", "", false},
+        {"example.com", "www.example.com.", "www", false},
+        {"Example.COM.", "a.b.example.com.", "a.b", false},
+        {"example.com.", "www.example.net.", "", true},
+        {"example.com.", "wwwexample.com.", "", true},
+        {"example.com.", "com.", "", true},
+    }
+
+    for _, test := range tests {
+        subname, err := DesecSubname(test.zone, test.name)
+        if (err != nil) != test.err {
+            t.Errorf("DesecSubname(%q, %q) error %v, expected error %v", test.zone, test.name, err, test.err)
+            continue
+        }
+        if subname != test.subname {
+            t.Errorf("DesecSubname(%q, %q) = %q, expected %q", test.zone, test.name, subname, test.subname)
+        }
+    }
+}
+
+// A fake deSEC.io API keeping the RRsets of one domain
+type desecFake struct {
+    m       sync.Mutex
+    domain  string
+    rrsets  map[string]*DesecRRset
+    patches [][]*DesecRRset
+}
+
+func (f *desecFake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    f.m.Lock()
+    defer f.m.Unlock()
+
+    if r.Header.Get("Authorization") != "Token secret" {
+        http.Error(w, "invalid token", http.StatusUnauthorized)
+        return
+    }
+
+    prefix := "/domains/" + f.domain + "/rrsets/"
+    if !strings.HasPrefix(r.URL.Path, prefix) {
+        http.NotFound(w, r)
+        return
+    }
+    path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+    switch {
+    case r.Method == "GET" && path != "":
+        p := strings.Split(path, "/")
+        if p[0] == "@" {
+            p[0] = ""
+        }
+        rrset, ok := f.rrsets[p[0]+"/"+p[1]]
+        if !ok {
+            http.NotFound(w, r)
+            return
+        }
+        json.NewEncoder(w).Encode(rrset)
+
+    case r.Method == "PATCH" && path == "":
+        if r.Header.Get("Content-Type") != "application/json" {
+            http.Error(w, "not json", http.StatusUnsupportedMediaType)
+            return
+        }
+        rrsets := []*DesecRRset{}
+        if err := json.NewDecoder(r.Body).Decode(&rrsets); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        f.patches = append(f.patches, rrsets)
+        for _, rrset := range rrsets {
+            if len(rrset.Records) == 0 {
+                delete(f.rrsets, rrset.Subname+"/"+rrset.Type)
+            } else {
+                f.rrsets[rrset.Subname+"/"+rrset.Type] = rrset
+            }
+        }
+        json.NewEncoder(w).Encode(rrsets)
+
+    default:
+        http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+    }
+}
+
+// Start a fake API with the given RRsets and configure signer s1 to use it,
+// the server is stopped and the previous Config restored when the test ends
+func desecTestSetup(t *testing.T, rrsets map[string]*DesecRRset) *desecFake {
+    fake := &desecFake{domain: "example.com", rrsets: rrsets}
+    server := httptest.NewServer(fake)
+    t.Cleanup(server.Close)
+
+    previous := Config
+    t.Cleanup(func() { Config = previous })
+    Config = NewConfig()
+    Config.Set("desec-api", server.URL)
+    Config.Set("signer-desec:s1", "t1")
+    Config.Set("desectoken-t1", "secret")
+
+    return fake
+}
+
+func desecTestRRs(t *testing.T, rrs ...string) *[][]dns.RR {
+    set := []dns.RR{}
+    for _, s := range rrs {
+        rr, err := dns.NewRR(s)
+        if err != nil {
+            t.Fatal(err)
+        }
+        set = append(set, rr)
+    }
+    return &[][]dns.RR{set}
+}
+
+func TestDesecUpdate(t *testing.T) {
+    fake := desecTestSetup(t, map[string]*DesecRRset{
+        "/NS": {Subname: "", Type: "NS", Ttl: 3600, Records: []string{"ns1.example.net.", "ns2.example.net."}},
+    })
+
+    updater := &DesecUpdater{}
+    output := []string{}
+
+    // ns3 is added and ns2 removed, ns1 which is not part of the change is
+    // kept and so is the TTL of the RRset
+    inserts := desecTestRRs(t, "example.com. 300 IN NS ns3.example.net.", "www.EXAMPLE.com. 300 IN A 192.0.2.1")
+    removes := desecTestRRs(t, "example.com. 300 IN NS ns2.example.net.")
+    if err := updater.Update("example.com.", "s1", inserts, removes, &output); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(fake.patches) != 1 {
+        t.Fatalf("expected 1 PATCH, got %d", len(fake.patches))
+    }
+    expected := []*DesecRRset{
+        {Subname: "", Type: "NS", Ttl: 3600, Records: []string{"ns1.example.net.", "ns3.example.net."}},
+        {Subname: "www", Type: "A", Ttl: 300, Records: []string{"192.0.2.1"}},
+    }
+    if !reflect.DeepEqual(fake.patches[0], expected) {
+        b, _ := json.Marshal(fake.patches[0])
+        t.Errorf("unexpected PATCH %s", b)
+    }
+
+    // the same change again is already in place and not sent
+    if err := updater.Update("example.com.", "s1", inserts, removes, &output); err != nil {
+        t.Fatal(err)
+    }
+    if len(fake.patches) != 1 {
+        t.Errorf("expected no PATCH for a change already made, got %d", len(fake.patches)-1)
+    }
+
+    // names outside of the zone are refused
+    outside := desecTestRRs(t, "www.example.net. 300 IN A 192.0.2.1")
+    if err := updater.Update("example.com.", "s1", outside, nil, &output); err == nil {
+        t.Error("expected an error for a name outside of the zone")
+    }
+    if len(fake.patches) != 1 {
+        t.Error("PATCH sent for a name outside of the zone")
+    }
+}
+
+func TestDesecRemoveRRset(t *testing.T) {
+    fake := desecTestSetup(t, map[string]*DesecRRset{
+        "/CDS": {Subname: "", Type: "CDS", Ttl: 3600, Records: []string{"12345 13 2 abcdef"}},
+    })
+
+    updater := &DesecUpdater{}
+    output := []string{}
+
+    rrsets := desecTestRRs(t, "example.com. 300 IN CDS 12345 13 2 ABCDEF", "example.com. 300 IN CDNSKEY 257 3 13 AQAB")
+    if err := updater.RemoveRRset("example.com.", "s1", *rrsets, &output); err != nil {
+        t.Fatal(err)
+    }
+
+    // only the CDS RRset exists and is removed
+    expected := [][]*DesecRRset{{{Subname: "", Type: "CDS", Records: []string{}}}}
+    if !reflect.DeepEqual(fake.patches, expected) {
+        b, _ := json.Marshal(fake.patches)
+        t.Errorf("unexpected PATCH %s", b)
+    }
+    if len(fake.rrsets) != 0 {
+        t.Errorf("RRsets left %v", fake.rrsets)
+    }
+}
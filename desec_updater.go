@@ -1,7 +1,9 @@
 package main
 
 import (
+    "encoding/json"
     "fmt"
+    "strings"
 
     "github.com/miekg/dns"
 )
@@ -13,68 +15,259 @@ func init() {
     Updaters["desec"] = &DesecUpdater{}
 }
 
+// A pending change of one RRset, identified by subname and type
+type desecChange struct {
+    subname string
+    type_   string
+    ttl     int
+    inserts []string
+    removes []string
+}
+
+// Return the RDATA of a RR in presentation format as used by deSEC.io
+func desecRecord(rr dns.RR) string {
+    return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// Normalize a record returned by deSEC.io so it can be compared with
+// records created by desecRecord()
+func desecNormalize(fqdn, type_, record string) string {
+    rr, err := dns.NewRR(fmt.Sprintf("%s 3600 IN %s %s", fqdn, type_, record))
+    if err != nil || rr == nil {
+        return record
+    }
+    return desecRecord(rr)
+}
+
+func desecChanges(fqdn string, inserts, removes *[][]dns.RR) ([]*desecChange, error) {
+    changes := []*desecChange{}
+    index := make(map[string]*desecChange)
+
+    get := func(rr dns.RR) (*desecChange, error) {
+        subname, err := DesecSubname(fqdn, rr.Header().Name)
+        if err != nil {
+            return nil, err
+        }
+        type_ := dns.TypeToString[rr.Header().Rrtype]
+        change, ok := index[subname+"/"+type_]
+        if !ok {
+            change = &desecChange{subname: subname, type_: type_}
+            index[subname+"/"+type_] = change
+            changes = append(changes, change)
+        }
+        return change, nil
+    }
+
+    if inserts != nil {
+        for _, insert := range *inserts {
+            for _, rr := range insert {
+                change, err := get(rr)
+                if err != nil {
+                    return nil, err
+                }
+                change.inserts = append(change.inserts, desecRecord(rr))
+                if int(rr.Header().Ttl) > change.ttl {
+                    change.ttl = int(rr.Header().Ttl)
+                }
+            }
+        }
+    }
+    if removes != nil {
+        for _, remove := range *removes {
+            for _, rr := range remove {
+                change, err := get(rr)
+                if err != nil {
+                    return nil, err
+                }
+                change.removes = append(change.removes, desecRecord(rr))
+            }
+        }
+    }
+
+    return changes, nil
+}
+
 func (d *DesecUpdater) Update(fqdn, signer string, inserts, removes *[][]dns.RR, output *[]string) error {
-    return fmt.Errorf("deSEC.io support not implemented")
+    inserts_len := 0
+    removes_len := 0
+    if inserts != nil {
+        for _, insert := range *inserts {
+            inserts_len += len(insert)
+        }
+    }
+    if removes != nil {
+        for _, remove := range *removes {
+            removes_len += len(remove)
+        }
+    }
+    if inserts_len == 0 && removes_len == 0 {
+        return fmt.Errorf("Inserts and removes empty, nothing to do")
+    }
+
+    client, err := NewDesecClient(signer)
+    if err != nil {
+        return err
+    }
+    domain := DesecDomain(fqdn)
+
+    debug := false
+    if Config.Get("debug-updater", "") == "yes" {
+        debug = true
+    }
+
+    *output = append(*output, fmt.Sprintf("desec: Sending inserts %d, removals %d to signer %s", inserts_len, removes_len, signer))
+
+    changes, err := desecChanges(fqdn, inserts, removes)
+    if err != nil {
+        return err
+    }
+
+    rrsets := []*DesecRRset{}
+    for _, change := range changes {
+        // merge with the existing RRset so records not part of the change are kept
+        existing, err := client.GetRRset(domain, change.subname, change.type_)
+        if err != nil {
+            return err
+        }
+
+        records := []string{}
+        have := make(map[string]bool)
+        ttl := change.ttl
+        if existing != nil {
+            for _, r := range existing.Records {
+                r = desecNormalize(fqdn, change.type_, r)
+                if !have[r] {
+                    have[r] = true
+                    records = append(records, r)
+                }
+            }
+            // deSEC.io has one TTL per RRset, keep the one already in use
+            if existing.Ttl > 0 {
+                ttl = existing.Ttl
+            }
+        }
+
+        changed := false
+        remove := make(map[string]bool)
+        for _, r := range change.removes {
+            if have[r] {
+                remove[r] = true
+                changed = true
+            }
+        }
+        n := []string{}
+        for _, r := range records {
+            if !remove[r] {
+                n = append(n, r)
+            }
+        }
+        records = n
+        for _, r := range change.inserts {
+            if !have[r] || remove[r] {
+                have[r] = true
+                delete(remove, r)
+                records = append(records, r)
+                changed = true
+            }
+        }
+
+        if !changed {
+            continue
+        }
+
+        rrsets = append(rrsets, &DesecRRset{
+            Subname: change.subname,
+            Type:    change.type_,
+            Records: records,
+            Ttl:     ttl,
+        })
+    }
+
+    if len(rrsets) == 0 {
+        *output = append(*output, fmt.Sprintf("desec: Signer %s already up to date", signer))
+        return nil
+    }
+
+    if debug {
+        b, err := json.Marshal(rrsets)
+        if err != nil {
+            return err
+        }
+        *output = append(*output, string(b))
+    }
+
+    if err := client.PatchRRsets(domain, rrsets); err != nil {
+        return err
+    }
+    *output = append(*output, fmt.Sprintf("desec: Updated %d rrset(s)", len(rrsets)))
+
+    return nil
 }
 
 func (d *DesecUpdater) RemoveRRset(fqdn, signer string, rrsets [][]dns.RR, output *[]string) error {
-    return fmt.Errorf("deSEC.io support not implemented")
-}
+    rrsets_len := 0
+    for _, rrset := range rrsets {
+        rrsets_len += len(rrset)
+    }
+    if rrsets_len == 0 {
+        return fmt.Errorf("rrset(s) is empty, nothing to do")
+    }
+
+    client, err := NewDesecClient(signer)
+    if err != nil {
+        return err
+    }
+    domain := DesecDomain(fqdn)
 
-// token := Config.Get("signer-desec:"+osigner, "")
-// if token == "" {
-//     *output = append(*output, fmt.Sprintf("Missing signer %s deSEC token, can't sync %s keys to it", osigner, signer))
-//     continue
-// }
-//
-// secret := Config.Get("desectoken-"+token, "")
-// if secret == "" {
-//     *output = append(*output, fmt.Sprintf("Missing deSEC token %s, can't sync %s keys to %s", token, signer, osigner))
-//     continue
-// }
-//
-// zone := args[0]
-// if zone[len(zone)-1] == '.' {
-//     zone = zone[:len(zone)-1]
-// }
-//
-// rrset := &DesecRRset{
-//     Subname: "",
-//     Type:    "DNSKEY",
-//     Records: []string{fmt.Sprintf("%d %d %d %s", key.Flags, key.Protocol, key.Algorithm, key.PublicKey)},
-//     Ttl:     ttl,
-// }
-//
-// *output = append(*output, "POST:")
-// *output = append(*output, fmt.Sprintf("  %v", rrset))
-//
-// body, err := json.Marshal(rrset)
-// if err != nil {
-//     return err
-// }
-// *output = append(*output, string(body))
-//
-// req, err := http.NewRequest("POST", fmt.Sprintf("https://desec.io/api/v1/domains/%s/rrsets/", zone), bytes.NewReader(body))
-// if err != nil {
-//     return err
-// }
-// req.Header.Add("Authorization", fmt.Sprintf("Token %s", secret))
-// req.Header.Add("Content-Type", "application/json")
-//
-// client := &http.Client{}
-// resp, err := client.Do(req)
-// if err != nil {
-//     return err
-// }
-// defer resp.Body.Close()
-// body, err = ioutil.ReadAll(resp.Body)
-// if err != nil {
-//     return err
-// }
-//
-// rrset = &DesecRRset{}
-// json.Unmarshal(body, &rrset)
-// *output = append(*output, "Response:")
-// *output = append(*output, resp.Status)
-// *output = append(*output, fmt.Sprintf("  %v", rrset))
-// break
+    *output = append(*output, fmt.Sprintf("desec: Sending remove rrset(s) %d to signer %s", rrsets_len, signer))
+
+    remove := []*DesecRRset{}
+    seen := make(map[string]bool)
+    for _, rrset := range rrsets {
+        for _, rr := range rrset {
+            subname, err := DesecSubname(fqdn, rr.Header().Name)
+            if err != nil {
+                return err
+            }
+            type_ := dns.TypeToString[rr.Header().Rrtype]
+            if seen[subname+"/"+type_] {
+                continue
+            }
+            seen[subname+"/"+type_] = true
+
+            existing, err := client.GetRRset(domain, subname, type_)
+            if err != nil {
+                return err
+            }
+            if existing == nil {
+                continue
+            }
+
+            remove = append(remove, &DesecRRset{
+                Subname: subname,
+                Type:    type_,
+                Records: []string{},
+            })
+        }
+    }
+
+    if len(remove) == 0 {
+        *output = append(*output, fmt.Sprintf("desec: No rrset(s) to remove in signer %s", signer))
+        return nil
+    }
+
+    if Config.Get("debug-updater", "") == "yes" {
+        b, err := json.Marshal(remove)
+        if err != nil {
+            return err
+        }
+        *output = append(*output, string(b))
+    }
+
+    if err := client.PatchRRsets(domain, remove); err != nil {
+        return err
+    }
+    *output = append(*output, fmt.Sprintf("desec: Removed %d rrset(s)", len(remove)))
+
+    return nil
+}
@@ -6,4 +6,6 @@ require (
 	github.com/google/uuid v1.2.0
 	github.com/miekg/dns v1.1.42
 	github.com/gorilla/websocket v1.4.2
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 )
@@ -6,12 +6,17 @@ import (
 
 func init() {
     Command["group-add"] = GroupAddCmd
-    Command["group-list"] = GroupListCmd
+    Command["group-list"] = CmdOutput(GroupListCmd)
     Command["group-remove"] = GroupRemoveCmd
+    Command["group-model"] = GroupModelCmd
 
     CommandHelp["group-add"] = "Add a new group, requires <fqdn> <parent ip|host> [port]"
     CommandHelp["group-list"] = "List groups"
     CommandHelp["group-remove"] = "Remove a group, can not be in use, requires <fqdn>"
+    CommandHelp["group-model"] = "Set or show the RFC 8901 model of a group, requires <fqdn> [1 <KSK signer>|2]"
+
+    CommandResult["group-list"] = GroupListCmd
+    CommandGlobal["group-remove"] = true
 }
 
 func GroupAddCmd(args []string, remote bool, output *[]string) error {
@@ -29,7 +34,7 @@ func GroupAddCmd(args []string, remote bool, output *[]string) error {
         *output = append(*output, fmt.Sprintf("Group %s added", args[0]))
 
         Config.Set("parent:"+args[0], args[1])
-        Config.Set("automate-stage:"+args[0], AutomateReady)
+        AutomateSetStage(args[0], AutomateReady)
     } else {
         *output = append(*output, fmt.Sprintf("Group %s already exists", args[0]))
     }
@@ -37,15 +42,23 @@ func GroupAddCmd(args []string, remote bool, output *[]string) error {
     return nil
 }
 
-func GroupListCmd(args []string, remote bool, output *[]string) error {
+type groupListEntry struct {
+    Name    string   `json:"name"`
+    Stage   string   `json:"stage"`
+    Signers []string `json:"signers"`
+}
+
+func GroupListCmd(args []string, remote bool, result *CmdResult) error {
     g := Config.ListGet("groups")
 
-    *output = append(*output, "Groups:")
+    groups := []groupListEntry{}
+    result.Output = append(result.Output, "Groups:")
     for _, v := range g {
-        *output = append(*output, fmt.Sprintf("  %s", v))
+        result.Output = append(result.Output, fmt.Sprintf("  %s", v))
+        groups = append(groups, groupListEntry{v, Config.Get("automate-stage:"+v, ""), Config.ListGet("signers:" + v)})
     }
 
-    return nil
+    return result.SetData(groups)
 }
 
 func GroupRemoveCmd(args []string, remote bool, output *[]string) error {
@@ -58,6 +71,7 @@ func GroupRemoveCmd(args []string, remote bool, output *[]string) error {
     }
 
     if Config.ListRemove("groups", args[0]) {
+        Automation.Remove(args[0])
         *output = append(*output, fmt.Sprintf("Group %s removed", args[0]))
     } else {
         *output = append(*output, fmt.Sprintf("Group %s did not exist", args[0]))
@@ -65,3 +79,57 @@ func GroupRemoveCmd(args []string, remote bool, output *[]string) error {
 
     return nil
 }
+
+func GroupModelCmd(args []string, remote bool, output *[]string) error {
+    if len(args) < 1 {
+        return fmt.Errorf("requires <fqdn> [1 <KSK signer>|2]")
+    }
+
+    if !Config.ListEntryExists("groups", args[0]) {
+        return fmt.Errorf("group %s does not exist", args[0])
+    }
+
+    if len(args) < 2 {
+        if holder := GroupKskSigner(args[0]); holder != "" {
+            *output = append(*output, fmt.Sprintf("Group %s uses model 1 with KSK signer %s", args[0], holder))
+        } else {
+            *output = append(*output, fmt.Sprintf("Group %s uses model 2", args[0]))
+        }
+        return nil
+    }
+
+    stage := Config.Get("automate-stage:"+args[0], "")
+    if stage != AutomateReady && stage != AutomateManual {
+        return fmt.Errorf("group %s is not ready for change (automate stage %s)", args[0], stage)
+    }
+
+    switch args[1] {
+    case "1":
+        if len(args) < 3 {
+            return fmt.Errorf("model 1 requires <KSK signer>")
+        }
+        if !Config.ListEntryExists("signers:"+args[0], args[2]) {
+            return fmt.Errorf("signer %s is not part of group %s", args[2], args[0])
+        }
+        Config.Set("group-model:"+args[0], "1")
+        Config.Set("group-ksk-signer:"+args[0], args[2])
+        *output = append(*output, fmt.Sprintf("Group %s now uses model 1 with KSK signer %s", args[0], args[2]))
+    case "2":
+        Config.Remove("group-model:" + args[0])
+        Config.Remove("group-ksk-signer:" + args[0])
+        *output = append(*output, fmt.Sprintf("Group %s now uses model 2", args[0]))
+    default:
+        return fmt.Errorf("unknown model %s", args[1])
+    }
+
+    return nil
+}
+
+// Return the signer holding the shared KSK of a RFC 8901 Model 1 group,
+// empty for Model 2 groups where every signer has its own KSK
+func GroupKskSigner(fqdn string) string {
+    if Config.Get("group-model:"+fqdn, "2") != "1" {
+        return ""
+    }
+    return Config.Get("group-ksk-signer:"+fqdn, "")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "sync"
+)
+
+// Locks used by the daemon so that commands and automation for different
+// groups can run at the same time.
+//
+// A command for a group (see CommandGroup) holds ConfigLock for reading and
+// the lock of that group. Commands that are not for a group, or are listed in
+// CommandGlobal, hold ConfigLock for writing and runs alone.
+var ConfigLock sync.RWMutex
+
+var groupLocks = make(map[string]*sync.Mutex)
+var groupLocksLock sync.Mutex
+
+func groupLock(fqdn string) *sync.Mutex {
+    groupLocksLock.Lock()
+    defer groupLocksLock.Unlock()
+
+    l, ok := groupLocks[fqdn]
+    if !ok {
+        l = &sync.Mutex{}
+        groupLocks[fqdn] = l
+    }
+    return l
+}
+
+// Lock a group, returns the function to unlock it
+func LockGroup(fqdn string) func() {
+    ConfigLock.RLock()
+    l := groupLock(fqdn)
+    l.Lock()
+    return func() {
+        l.Unlock()
+        ConfigLock.RUnlock()
+    }
+}
+
+// Store the config when no command or automation step is running, so that
+// only the changes of complete commands and steps are written
+func StoreConfig() error {
+    ConfigLock.Lock()
+    defer ConfigLock.Unlock()
+
+    return Config.Store()
+}
+
+// Return the group a command locks, empty if it locks the whole config
+func commandLockGroup(args []string) string {
+    if CommandGlobal[args[0]] {
+        return ""
+    }
+    return CommandGroup(args)
+}
+
+// Lock what a command needs, returns the function to unlock it
+func LockCommand(args []string) func() {
+    if group := commandLockGroup(args); group != "" {
+        return LockGroup(group)
+    }
+
+    ConfigLock.Lock()
+    return ConfigLock.Unlock
+}
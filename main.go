@@ -1,22 +1,32 @@
 package main
 
 import (
+    "encoding/json"
     "flag"
+    "fmt"
+    "io/ioutil"
     "log"
     "net/http"
-    "net/rpc"
     "os"
     "os/signal"
     "runtime"
     "runtime/pprof"
+    "strings"
     "time"
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var memstats = flag.Bool("memstats", false, "output memstats")
 var conf = flag.String("conf", "", "config file to use")
+var storageType = flag.String("storage", "json", "storage of the config file, json or bolt")
 var remote = flag.String("remote", "", "specify remote daemon to execute commands on [<server|ip>]:<port>")
 var httpAddr = flag.String("http", "", "http service address")
+var jsonOutput = flag.Bool("json", false, "output the result of the command as JSON")
+var remoteCa = flag.String("remote-ca", "", "CA certificate file to verify the remote daemon with, enables TLS")
+var remoteCert = flag.String("remote-cert", "", "client certificate file to authenticate to the remote daemon with, enables TLS")
+var remoteKey = flag.String("remote-key", "", "key file of the client certificate, default is the certificate file")
+var notifyCommand = flag.String("notify", "", "command to run on automation notifications, given the group and the message as arguments")
+var remoteToken = flag.String("remote-token", "", "file containing the token to authenticate to the remote daemon with")
 
 func main() {
     flag.Parse()
@@ -93,6 +103,7 @@ func run() int {
             http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
                 serveWs(w, r)
             })
+            http.HandleFunc(ApiPrefix, ServeApi)
             log.Println("HTTP on", *httpAddr)
             err := http.ListenAndServe(*httpAddr, nil)
             if err != nil {
@@ -102,12 +113,38 @@ func run() int {
     }
 
     if *remote != "" {
-        client, err := rpc.DialHTTP("tcp", *remote)
+        token := ""
+        if *remoteToken != "" {
+            b, err := ioutil.ReadFile(*remoteToken)
+            if err != nil {
+                log.Fatal(err)
+            }
+            token = strings.TrimSpace(string(b))
+        }
+
+        client, err := DialRemote(*remote, *remoteCa, *remoteCert, *remoteKey, token)
         if err != nil {
             log.Fatal("dialing:", err)
         }
         defer client.Close()
 
+        if *jsonOutput {
+            var result CmdResult
+            if err := client.Call("Rpc.CallResult", args, &result); err != nil {
+                printJson(&CmdResult{Command: args[0], Output: []string{}, Error: err.Error()})
+                return 1
+            }
+            // gob does not keep empty slices
+            if result.Output == nil {
+                result.Output = []string{}
+            }
+            printJson(&result)
+            if result.Error != "" {
+                return 1
+            }
+            return 0
+        }
+
         var reply []string
         err = client.Call("Rpc.Call", args, &reply)
         if err != nil {
@@ -123,46 +160,83 @@ func run() int {
     if *conf == "" {
         log.Fatal("-conf <file> must be specified")
     }
-    if _, err := os.Stat(*conf); !os.IsNotExist(err) {
-        log.Println("Loading config", *conf)
-        if err := Config.Load(*conf); err != nil {
-            log.Fatal(err)
-        }
+    if err := LockConfigFile(*conf); err != nil {
+        log.Fatal(err)
+    }
+    storage, err := OpenConfigStorage(*storageType, *conf)
+    if err != nil {
+        log.Fatal(err)
+    }
+    log.Println("Loading config", *conf)
+    if err := Config.Open(storage); err != nil {
+        log.Fatal(err)
+    }
+    AuditFile = Config.Get("audit-file", *conf+".audit")
+    AutomateNotifyCommand = *notifyCommand
+    if Config.Exists("automate-notify") {
+        log.Println("Warning: automate-notify is no longer used, use -notify instead")
+    }
+    // the daemon registers the caller of each command and automation step,
+    // only the changes made while it starts are made by the local caller
+    endAudit := AuditBegin("", AuditLocalCaller(args))
+    if err := LoadSecrets(*conf); err != nil {
+        log.Fatal(err)
+    }
+    if args[0] == "daemon" {
+        endAudit()
+    } else {
+        defer endAudit()
     }
-    // Daemon needs to know what config is used, it will save changes after each command
-    DaemonConf = *conf
-
     c := make(chan os.Signal, 1)
     signal.Notify(c, os.Interrupt)
     go func() {
         for range c {
             log.Println("Caught SIGINT")
 
-            if err := Config.Store(*conf); err != nil {
+            if err := StoreConfig(); err != nil {
                 log.Fatal(err)
             }
+            Config.Close()
 
             os.Exit(1)
         }
     }()
 
-    cmd, ok := Command[args[0]]
-    if !ok {
+    if _, ok := Command[args[0]]; !ok {
         log.Fatal("Command does not exist: ", args[0])
     }
 
-    var out []string
-    if err := cmd(args[1:], false, &out); err != nil {
-        log.Fatal("Command ", args[0], " error: ", err)
-    }
+    result, err := RunCommand(args, false)
+    AutomateNotifyWait()
+    if *jsonOutput {
+        printJson(result)
+        if err != nil {
+            return 1
+        }
+    } else {
+        if err != nil {
+            log.Fatal("Command ", args[0], " error: ", err)
+        }
 
-    for _, v := range out {
-        log.Println(v)
+        for _, v := range result.Output {
+            log.Println(v)
+        }
     }
 
-    if err := Config.Store(*conf); err != nil {
+    if err := Config.Store(); err != nil {
+        log.Fatal(err)
+    }
+    if err := Config.Close(); err != nil {
         log.Fatal(err)
     }
 
     return 0
 }
+
+func printJson(result *CmdResult) {
+    b, err := json.MarshalIndent(result, "", "  ")
+    if err != nil {
+        log.Fatal(err)
+    }
+    fmt.Println(string(b))
+}
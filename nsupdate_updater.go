@@ -41,9 +41,9 @@ func (n *NsupdateUpdater) Update(fqdn, signer string, inserts, removes *[][]dns.
         return fmt.Errorf("Missing signer %s TSIG key %s", signer, tsigkey)
     }
 
-    secret := Config.Get("tsigkey-"+tsigkey, "")
-    if secret == "" {
-        return fmt.Errorf("Missing TSIG key secret for %s", tsigkey)
+    algorithm, secret, err := GetTsigKey(tsigkey)
+    if err != nil {
+        return err
     }
 
     m := new(dns.Msg)
@@ -58,7 +58,7 @@ func (n *NsupdateUpdater) Update(fqdn, signer string, inserts, removes *[][]dns.
             m.Remove(remove)
         }
     }
-    m.SetTsig(tsigkey+".", dns.HmacSHA256, 300, time.Now().Unix())
+    m.SetTsig(tsigkey+".", algorithm, 300, time.Now().Unix())
 
     debug := false
     if Config.Get("debug-updater", "") == "yes" {
@@ -73,7 +73,10 @@ func (n *NsupdateUpdater) Update(fqdn, signer string, inserts, removes *[][]dns.
     c := new(dns.Client)
     c.TsigSecret = map[string]string{tsigkey + ".": secret}
     in, rtt, err := c.Exchange(m, ip)
-    if err != nil {
+    if err := CheckUpdateResponse(signer, in, err); err != nil {
+        if debug && in != nil {
+            *output = append(*output, in.String())
+        }
         return err
     }
 
@@ -104,9 +107,9 @@ func (d *NsupdateUpdater) RemoveRRset(fqdn, signer string, rrsets [][]dns.RR, ou
         return fmt.Errorf("Missing signer %s TSIG key %s", signer, tsigkey)
     }
 
-    secret := Config.Get("tsigkey-"+tsigkey, "")
-    if secret == "" {
-        return fmt.Errorf("Missing TSIG key secret for %s", tsigkey)
+    algorithm, secret, err := GetTsigKey(tsigkey)
+    if err != nil {
+        return err
     }
 
     m := new(dns.Msg)
@@ -114,7 +117,7 @@ func (d *NsupdateUpdater) RemoveRRset(fqdn, signer string, rrsets [][]dns.RR, ou
     for _, rrset := range rrsets {
         m.RemoveRRset(rrset)
     }
-    m.SetTsig(tsigkey+".", dns.HmacSHA256, 300, time.Now().Unix())
+    m.SetTsig(tsigkey+".", algorithm, 300, time.Now().Unix())
 
     debug := false
     if Config.Get("debug-updater", "") == "yes" {
@@ -129,7 +132,10 @@ func (d *NsupdateUpdater) RemoveRRset(fqdn, signer string, rrsets [][]dns.RR, ou
     c := new(dns.Client)
     c.TsigSecret = map[string]string{tsigkey + ".": secret}
     in, rtt, err := c.Exchange(m, ip)
-    if err != nil {
+    if err := CheckUpdateResponse(signer, in, err); err != nil {
+        if debug && in != nil {
+            *output = append(*output, in.String())
+        }
         return err
     }
 
@@ -0,0 +1,83 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "path"
+)
+
+// A rule of the policy file, commands and groups are shell patterns (see
+// path.Match) so "status*" allows all status commands and "*" all groups
+type PolicyRule struct {
+    Commands []string `json:"commands"`
+    Groups   []string `json:"groups"`
+}
+
+// Load the policy file configured with daemon-policy, the rules are mapped
+// by identity and the rule for "*" is used for identities not listed. Returns
+// nil if no policy is configured.
+func LoadPolicy() (map[string]*PolicyRule, error) {
+    file := Config.Get("daemon-policy", "")
+    if file == "" {
+        return nil, nil
+    }
+
+    data, err := ioutil.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+
+    policy := make(map[string]*PolicyRule)
+    if err := json.Unmarshal(data, &policy); err != nil {
+        return nil, fmt.Errorf("policy %s: %s", file, err)
+    }
+
+    return policy, nil
+}
+
+func policyMatch(patterns []string, name string) bool {
+    for _, p := range patterns {
+        if ok, _ := path.Match(p, name); ok {
+            return true
+        }
+    }
+    return false
+}
+
+// Check if identity is allowed to call a command, returns an error with the
+// reason if denied. Commands that are not for a group require that the rule
+// allows all groups ("*").
+func PolicyAllowed(identity string, args []string) error {
+    policy, err := LoadPolicy()
+    if err != nil {
+        return err
+    }
+    if policy == nil {
+        return nil
+    }
+
+    rule, ok := policy[identity]
+    if !ok {
+        if rule, ok = policy["*"]; !ok {
+            return fmt.Errorf("%s has no policy", identity)
+        }
+    }
+
+    if !policyMatch(rule.Commands, args[0]) {
+        return fmt.Errorf("%s is not allowed to call %s", identity, args[0])
+    }
+
+    group := CommandGroup(args)
+    if group == "" {
+        group = "*"
+    }
+    if !policyMatch(rule.Groups, group) {
+        if group == "*" {
+            return fmt.Errorf("%s is not allowed to call %s outside of a group", identity, args[0])
+        }
+        return fmt.Errorf("%s is not allowed to call %s for group %s", identity, args[0], group)
+    }
+
+    return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/miekg/dns"
+)
+
+func init() {
+    Command["signer-preflight"] = SignerPreflightCmd
+
+    CommandHelp["signer-preflight"] = "Check that a signer is compatible with the other signers in a group, requires <group> <name> [ip|host] [port]"
+}
+
+// What a signer serves for a zone that is relevant for the compatibility
+// with other signers
+type preflightInfo struct {
+    Algorithms []uint8
+    Nsec3Param *dns.NSEC3PARAM
+    Nses       []string
+}
+
+func SignerPreflightCmd(args []string, remote bool, output *[]string) error {
+    if len(args) < 2 {
+        return fmt.Errorf("requires <group> <name> [ip|host] [port]")
+    }
+
+    if !Config.ListEntryExists("groups", args[0]) {
+        return fmt.Errorf("group %s does not exist", args[0])
+    }
+
+    // the candidate does not need to be added to the group yet if an
+    // address is given
+    ip := Config.Get("signer:"+args[1], "")
+    if len(args) > 2 {
+        ip = args[2] + ":53"
+        if len(args) > 3 {
+            ip = args[2] + ":" + args[3]
+        }
+    }
+    if ip == "" {
+        return fmt.Errorf("No ip|host for signer %s", args[1])
+    }
+
+    return SignerPreflight(args[0], args[1], ip, output)
+}
+
+// Check that the signer name at ip is compatible with the existing signers in
+// a group, it needs to be reachable, be authoritative for the zone and use the
+// same DNSKEY algorithms and denial-of-existence (NSEC or NSEC3). Differences
+// that do not break the group are only warned about.
+func SignerPreflight(fqdn, name, ip string, output *[]string) error {
+    *output = append(*output, fmt.Sprintf("Preflight of signer %s (%s) for %s", name, ip, fqdn))
+
+    candidate, err := preflightQuery(fqdn, name, ip)
+    if err != nil {
+        *output = append(*output, fmt.Sprintf("  %s unreachable: %s", name, err))
+        return fmt.Errorf("preflight of signer %s failed: unreachable", name)
+    }
+
+    if len(candidate.Algorithms) == 0 {
+        *output = append(*output, fmt.Sprintf("  %s has no DNSKEYs for %s", name, fqdn))
+        return fmt.Errorf("preflight of signer %s failed: no DNSKEYs", name)
+    }
+
+    if ns := Config.Get("signer-ns:"+name, ""); ns != "" {
+        found := false
+        for _, n := range candidate.Nses {
+            if n == ns {
+                found = true
+                break
+            }
+        }
+        if !found {
+            *output = append(*output, fmt.Sprintf("  Warning: %s does not serve its own NS %s", name, ns))
+        }
+    }
+
+    reasons := []string{}
+    for _, signer := range Config.ListGet("signers:" + fqdn) {
+        if signer == name || Config.Get("signer-leaving:"+signer, "") != "" {
+            continue
+        }
+
+        oip := Config.Get("signer:"+signer, "")
+        if oip == "" {
+            return fmt.Errorf("No ip|host for signer %s", signer)
+        }
+        other, err := preflightQuery(fqdn, signer, oip)
+        if err != nil {
+            *output = append(*output, fmt.Sprintf("  %s unreachable: %s", signer, err))
+            reasons = append(reasons, fmt.Sprintf("%s unreachable", signer))
+            continue
+        }
+
+        if a, o := preflightAlgorithms(candidate.Algorithms), preflightAlgorithms(other.Algorithms); a != o {
+            *output = append(*output, fmt.Sprintf("  Algorithm mismatch: %s uses %s, %s uses %s", name, a, signer, o))
+            reasons = append(reasons, fmt.Sprintf("algorithm mismatch with %s", signer))
+        }
+
+        switch {
+        case (candidate.Nsec3Param == nil) != (other.Nsec3Param == nil):
+            *output = append(*output, fmt.Sprintf("  Denial-of-existence mismatch: %s uses %s, %s uses %s", name, preflightNsec(candidate), signer, preflightNsec(other)))
+            reasons = append(reasons, fmt.Sprintf("NSEC/NSEC3 mismatch with %s", signer))
+        case candidate.Nsec3Param != nil:
+            a, o := candidate.Nsec3Param, other.Nsec3Param
+            if a.Hash != o.Hash || a.Flags != o.Flags || a.Iterations != o.Iterations || a.Salt != o.Salt {
+                *output = append(*output, fmt.Sprintf("  Warning: NSEC3 parameters differ: %s has %s, %s has %s", name, preflightRdata(a), signer, preflightRdata(o)))
+            }
+        }
+    }
+
+    if len(reasons) > 0 {
+        return fmt.Errorf("preflight of signer %s failed: %s", name, strings.Join(reasons, ", "))
+    }
+
+    *output = append(*output, fmt.Sprintf("  Signer %s is compatible with %s", name, fqdn))
+    return nil
+}
+
+// Run the preflight for all signers in a group, used before joining
+func PreflightGroupCmd(args []string, remote bool, output *[]string) error {
+    if len(args) < 1 {
+        return fmt.Errorf("requires <fqdn>")
+    }
+
+    for _, signer := range Config.ListGet("signers:" + args[0]) {
+        if Config.Get("signer-leaving:"+signer, "") != "" {
+            continue
+        }
+        if err := SignerPreflight(args[0], signer, Config.Get("signer:"+signer, ""), output); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func preflightQuery(fqdn, signer, ip string) (*preflightInfo, error) {
+    info := &preflightInfo{}
+
+    r, err := Query(ip, signer, fqdn, dns.TypeSOA)
+    if err != nil {
+        return nil, err
+    }
+    if !r.Authoritative || len(r.Answer) == 0 {
+        return nil, fmt.Errorf("not authoritative for %s", fqdn)
+    }
+
+    r, err = Query(ip, signer, fqdn, dns.TypeDNSKEY)
+    if err != nil {
+        return nil, err
+    }
+    algorithms := make(map[uint8]bool)
+    for _, a := range r.Answer {
+        if dnskey, ok := a.(*dns.DNSKEY); ok {
+            algorithms[dnskey.Algorithm] = true
+        }
+    }
+    for algorithm := range algorithms {
+        info.Algorithms = append(info.Algorithms, algorithm)
+    }
+
+    r, err = Query(ip, signer, fqdn, dns.TypeNSEC3PARAM)
+    if err != nil {
+        return nil, err
+    }
+    for _, a := range r.Answer {
+        if nsec3param, ok := a.(*dns.NSEC3PARAM); ok {
+            info.Nsec3Param = nsec3param
+        }
+    }
+
+    r, err = Query(ip, signer, fqdn, dns.TypeNS)
+    if err != nil {
+        return nil, err
+    }
+    for _, a := range r.Answer {
+        if ns, ok := a.(*dns.NS); ok {
+            info.Nses = append(info.Nses, ns.Ns)
+        }
+    }
+
+    return info, nil
+}
+
+func preflightAlgorithms(algorithms []uint8) string {
+    names := []string{}
+    for _, algorithm := range algorithms {
+        names = append(names, dns.AlgorithmToString[algorithm])
+    }
+    sort.Strings(names)
+    return strings.Join(names, ",")
+}
+
+func preflightNsec(info *preflightInfo) string {
+    if info.Nsec3Param != nil {
+        return "NSEC3"
+    }
+    return "NSEC"
+}
+
+func preflightRdata(rr dns.RR) string {
+    return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+const QueryDefaultUDPSize = 1232
+
+// Query a server for qname and qtype using EDNS0 with the DO bit set, if the
+// response is truncated the query is retried over TCP.
+//
+// If signer is given and signer-query-tsig:<signer> is set to yes then the
+// query is signed with the TSIG key of the signer.
+func Query(server, signer, qname string, qtype uint16) (*dns.Msg, error) {
+    size, err := strconv.Atoi(Config.Get("query-udp-size", strconv.Itoa(QueryDefaultUDPSize)))
+    if err != nil || size < dns.MinMsgSize || size > dns.MaxMsgSize {
+        size = QueryDefaultUDPSize
+    }
+
+    c := new(dns.Client)
+    c.UDPSize = uint16(size)
+    if Config.Get("query-tcp", "") == "yes" {
+        c.Net = "tcp"
+    }
+
+    tsigkey := ""
+    algorithm := ""
+    if signer != "" && Config.Get("signer-query-tsig:"+signer, "") == "yes" {
+        tsigkey = Config.Get("signer-tsigkey:"+signer, "")
+        if tsigkey == "" {
+            return nil, fmt.Errorf("Missing signer %s TSIG key for queries", signer)
+        }
+        var secret string
+        algorithm, secret, err = GetTsigKey(tsigkey)
+        if err != nil {
+            return nil, err
+        }
+        c.TsigSecret = map[string]string{tsigkey + ".": secret}
+    }
+
+    // the message is recreated for a retry since the TSIG is consumed when sent
+    msg := func() *dns.Msg {
+        m := new(dns.Msg)
+        m.SetQuestion(qname, qtype)
+        m.SetEdns0(uint16(size), true)
+        if tsigkey != "" {
+            m.SetTsig(tsigkey+".", algorithm, 300, time.Now().Unix())
+        }
+        return m
+    }
+
+    r, _, err := c.Exchange(msg(), server)
+    if err == nil && r.Truncated && c.Net != "tcp" {
+        c.Net = "tcp"
+        r, _, err = c.Exchange(msg(), server)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("Query %s %s to %s failed: %s", qname, dns.TypeToString[qtype], server, err)
+    }
+
+    if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+        return nil, fmt.Errorf("Query %s %s to %s failed: rcode %s", qname, dns.TypeToString[qtype], server, dns.RcodeToString[r.Rcode])
+    }
+
+    return r, nil
+}
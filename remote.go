@@ -0,0 +1,190 @@
+package main
+
+import (
+    "bufio"
+    "crypto/subtle"
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net"
+    "net/http"
+    "net/rpc"
+    "strings"
+)
+
+// The response the RPC server gives to a successful CONNECT, same as net/rpc
+const remoteConnected = "200 Connected to Go RPC"
+
+// Load a PEM file with one or more CA certificates
+func loadCertPool(file string) (*x509.CertPool, error) {
+    pem, err := ioutil.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("no certificates found in %s", file)
+    }
+    return pool, nil
+}
+
+// Return the TLS config for the daemon's RPC listener, nil if TLS is not
+// configured (daemon-tls-cert and daemon-tls-key). If daemon-tls-client-ca
+// is set then client certificates signed by it are verified and used as
+// identity of the caller.
+func DaemonTlsConfig() (*tls.Config, error) {
+    certFile := Config.Get("daemon-tls-cert", "")
+    keyFile := Config.Get("daemon-tls-key", "")
+    if certFile == "" && keyFile == "" {
+        return nil, nil
+    }
+    if certFile == "" || keyFile == "" {
+        return nil, fmt.Errorf("both daemon-tls-cert and daemon-tls-key must be set")
+    }
+
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        return nil, err
+    }
+    config := &tls.Config{
+        Certificates: []tls.Certificate{cert},
+        MinVersion:   tls.VersionTLS12,
+    }
+
+    if ca := Config.Get("daemon-tls-client-ca", ""); ca != "" {
+        pool, err := loadCertPool(ca)
+        if err != nil {
+            return nil, err
+        }
+        config.ClientCAs = pool
+        config.ClientAuth = tls.VerifyClientCertIfGiven
+    }
+
+    return config, nil
+}
+
+// Return the identity of a RPC caller, this is the common name of a verified
+// client certificate or the name of the matching daemon-token:<name>. Other
+// callers are anonymous if daemon-allow-anonymous is yes, otherwise they get
+// an empty identity.
+func DaemonIdentity(r *http.Request) string {
+    if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+        return r.TLS.VerifiedChains[0][0].Subject.CommonName
+    }
+
+    tokens := SecretKeys("daemon-token:")
+    if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+        for _, k := range tokens {
+            if subtle.ConstantTimeCompare([]byte(token), []byte(GetSecret(k))) == 1 {
+                return strings.TrimPrefix(k, "daemon-token:")
+            }
+        }
+    }
+
+    if Config.Get("daemon-allow-anonymous", "") == "yes" {
+        return "anonymous"
+    }
+    return ""
+}
+
+// Log warnings about how callers authenticate to the daemon, tls tells if
+// the RPC listener uses TLS
+func DaemonAuthWarnings(tls bool) {
+    tokens := len(SecretKeys("daemon-token:")) > 0
+    if tokens && !tls {
+        log.Println("Warning: daemon-token: is set but daemon-tls-cert is not, tokens are sent in clear text")
+    }
+    if tokens && *httpAddr != "" {
+        log.Println("Warning: the REST API on -http does not use TLS, tokens are sent in clear text unless it is behind a TLS proxy")
+    }
+    if Config.Get("daemon-allow-anonymous", "") == "yes" {
+        log.Println("Warning: daemon-allow-anonymous is set, callers without credentials are accepted as anonymous")
+    } else if !tokens && Config.Get("daemon-tls-client-ca", "") == "" {
+        log.Println("Warning: neither daemon-token: nor daemon-tls-client-ca is set, no caller can authenticate")
+    }
+}
+
+// Serves RPC over HTTP like net/rpc but with a server for each connection so
+// that the identity of the caller is known to Rpc.Call
+type rpcHandler struct{}
+
+func (h rpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.Method != "CONNECT" {
+        w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+        w.WriteHeader(http.StatusMethodNotAllowed)
+        io.WriteString(w, "405 must CONNECT\n")
+        return
+    }
+
+    identity := DaemonIdentity(r)
+    if identity == "" {
+        log.Println("Unauthenticated RPC connection from", r.RemoteAddr)
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    conn, _, err := w.(http.Hijacker).Hijack()
+    if err != nil {
+        log.Println("rpc hijacking", r.RemoteAddr, ":", err)
+        return
+    }
+    io.WriteString(conn, "HTTP/1.0 "+remoteConnected+"\n\n")
+
+    server := rpc.NewServer()
+    server.Register(&Rpc{Identity: identity, Address: r.RemoteAddr})
+    server.ServeConn(conn)
+}
+
+// Dial a daemon, TLS is used if a CA or client certificate is given and the
+// token, if given, is sent for authentication
+func DialRemote(address, ca, cert, key, token string) (*rpc.Client, error) {
+    var conn net.Conn
+    var err error
+
+    if ca != "" || cert != "" {
+        config := &tls.Config{MinVersion: tls.VersionTLS12}
+        if ca != "" {
+            if config.RootCAs, err = loadCertPool(ca); err != nil {
+                return nil, err
+            }
+        }
+        if cert != "" {
+            if key == "" {
+                key = cert
+            }
+            c, err := tls.LoadX509KeyPair(cert, key)
+            if err != nil {
+                return nil, err
+            }
+            config.Certificates = []tls.Certificate{c}
+        }
+        conn, err = tls.Dial("tcp", address, config)
+    } else {
+        conn, err = net.Dial("tcp", address)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    req := "CONNECT " + rpc.DefaultRPCPath + " HTTP/1.0\n"
+    if token != "" {
+        if ca == "" && cert == "" {
+            log.Println("Warning: sending token without TLS, use -remote-ca")
+        }
+        req += "Authorization: Bearer " + token + "\n"
+    }
+    io.WriteString(conn, req+"\n")
+
+    resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+    if err == nil && resp.Status == remoteConnected {
+        return rpc.NewClient(conn), nil
+    }
+    conn.Close()
+    if err == nil {
+        err = fmt.Errorf("unexpected HTTP response: %s", resp.Status)
+    }
+    return nil, err
+}
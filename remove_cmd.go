@@ -9,9 +9,11 @@ import (
 func init() {
     Command["remove-cdscdnskeys"] = RemoveCdscdnskeysCmd
     Command["remove-csync"] = RemoveCsyncCmd
+    Command["remove-retired-zsks"] = RemoveRetiredZsksCmd
 
     CommandHelp["remove-cdscdnskeys"] = "Remove all CDS/CDNSKEYs from signers in a group, requires <fqdn>"
     CommandHelp["remove-csync"] = "Remove all CSYNCs from signers in a group, requires <fqdn>"
+    CommandHelp["remove-retired-zsks"] = "Remove ZSKs retired by their owner from all signers in a group, requires <fqdn>"
 }
 
 func RemoveCdscdnskeysCmd(args []string, remote bool, output *[]string) error {
@@ -66,3 +68,46 @@ func RemoveCsyncCmd(args []string, remote bool, output *[]string) error {
 
     return nil
 }
+
+func RemoveRetiredZsksCmd(args []string, remote bool, output *[]string) error {
+    if len(args) < 1 {
+        return fmt.Errorf("requires <fqdn>")
+    }
+
+    dnskeys, err := GroupDnskeys(args[0])
+    if err != nil {
+        return err
+    }
+
+    retired := RetiredZsks(dnskeys)
+
+    for signer, keys := range dnskeys {
+        if Config.Get("signer-leaving:"+signer, "") != "" {
+            continue
+        }
+
+        remove := []dns.RR{}
+        for _, key := range keys {
+            if _, ok := retired[fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey)]; ok {
+                remove = append(remove, key)
+            }
+        }
+        if len(remove) == 0 {
+            continue
+        }
+
+        updater := GetUpdater(Config.Get("signer-type:"+signer, "nsupdate"))
+        if err := updater.Update(args[0], signer, nil, &[][]dns.RR{remove}, output); err != nil {
+            return err
+        }
+        *output = append(*output, fmt.Sprintf("  Removed %d retired ZSK(s) from %s", len(remove), signer))
+    }
+
+    // in Model 1 the DNSKEY RRset signed without the retired ZSKs is
+    // distributed again
+    if holder := GroupKskSigner(args[0]); holder != "" && len(retired) > 0 {
+        return Model1Distribute(args[0], holder, output)
+    }
+
+    return nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/miekg/dns"
+)
+
+func init() {
+    Command["rollover-zsk"] = RolloverZskCmd
+    Command["rollover-ksk"] = RolloverKskCmd
+    Command["rollover-detect"] = RolloverDetectCmd
+
+    CommandHelp["rollover-zsk"] = "Start a coordinated ZSK rollover for a group, requires <fqdn>"
+    CommandHelp["rollover-ksk"] = "Start a coordinated KSK rollover (double-DS) for a group, requires <fqdn>"
+    CommandHelp["rollover-detect"] = "Enable or disable automatic detection of key rollovers for a group, requires <fqdn> <yes|no>"
+}
+
+func RolloverZskCmd(args []string, remote bool, output *[]string) error {
+    if len(args) < 1 {
+        return fmt.Errorf("requires <fqdn>")
+    }
+
+    if !Config.ListEntryExists("groups", args[0]) {
+        return fmt.Errorf("group %s does not exist", args[0])
+    }
+
+    stage := Config.Get("automate-stage:"+args[0], "")
+    if stage != AutomateReady {
+        return fmt.Errorf("group %s is not ready for a rollover (automate stage %s)", args[0], stage)
+    }
+
+    AutomateSetStage(args[0], AutomateRolloverZskSyncDnskeys)
+    *output = append(*output, fmt.Sprintf("Automation for %s now %s", args[0], AutomateRolloverZskSyncDnskeys))
+
+    return nil
+}
+
+func RolloverKskCmd(args []string, remote bool, output *[]string) error {
+    if len(args) < 1 {
+        return fmt.Errorf("requires <fqdn>")
+    }
+
+    if !Config.ListEntryExists("groups", args[0]) {
+        return fmt.Errorf("group %s does not exist", args[0])
+    }
+
+    stage := Config.Get("automate-stage:"+args[0], "")
+    if stage != AutomateReady {
+        return fmt.Errorf("group %s is not ready for a rollover (automate stage %s)", args[0], stage)
+    }
+
+    AutomateSetStage(args[0], AutomateRolloverKskSyncCdscdnskeys)
+    *output = append(*output, fmt.Sprintf("Automation for %s now %s", args[0], AutomateRolloverKskSyncCdscdnskeys))
+
+    return nil
+}
+
+func RolloverDetectCmd(args []string, remote bool, output *[]string) error {
+    if len(args) < 2 {
+        return fmt.Errorf("requires <fqdn> <yes|no>")
+    }
+
+    if !Config.ListEntryExists("groups", args[0]) {
+        return fmt.Errorf("group %s does not exist", args[0])
+    }
+
+    switch args[1] {
+    case "yes":
+        Config.Set("group-rollover-detect:"+args[0], "yes")
+        *output = append(*output, "Rollover detection enabled for "+args[0])
+    case "no":
+        Config.Remove("group-rollover-detect:" + args[0])
+        *output = append(*output, "Rollover detection disabled for "+args[0])
+    default:
+        return fmt.Errorf("requires <fqdn> <yes|no>")
+    }
+
+    return nil
+}
+
+// Query all signers in a group for their DNSKEYs
+func GroupDnskeys(fqdn string) (map[string][]*dns.DNSKEY, error) {
+    if !Config.Exists("signers:" + fqdn) {
+        return nil, fmt.Errorf("group %s has no signers", fqdn)
+    }
+
+    dnskeys := make(map[string][]*dns.DNSKEY)
+
+    for _, signer := range Config.ListGet("signers:" + fqdn) {
+        ip := Config.Get("signer:"+signer, "")
+        if ip == "" {
+            return nil, fmt.Errorf("No ip|host for signer %s", signer)
+        }
+
+        r, err := Query(ip, signer, fqdn, dns.TypeDNSKEY)
+        if err != nil {
+            return nil, err
+        }
+
+        dnskeys[signer] = []*dns.DNSKEY{}
+        for _, a := range r.Answer {
+            if dnskey, ok := a.(*dns.DNSKEY); ok {
+                dnskeys[signer] = append(dnskeys[signer], dnskey)
+            }
+        }
+    }
+
+    return dnskeys, nil
+}
+
+// Return the ZSKs that have been retired, these are ZSKs that are no longer
+// published by the signer they originated from (see dnskey-origin) but still
+// exist in other signers. The keys are mapped by their dnskey-origin name.
+//
+// Keys from leaving signers are not included, they are handled by the leave
+// workflow.
+func RetiredZsks(dnskeys map[string][]*dns.DNSKEY) map[string]*dns.DNSKEY {
+    retired := make(map[string]*dns.DNSKEY)
+
+    for signer, keys := range dnskeys {
+        for _, key := range keys {
+            if f := key.Flags & 0x101; f != 256 {
+                continue
+            }
+
+            origin := fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey)
+            owner := Config.Get("dnskey-origin:"+origin, "")
+            if owner == "" || owner == signer {
+                continue
+            }
+            if Config.Get("signer-leaving:"+owner, "") != "" {
+                continue
+            }
+            okeys, ok := dnskeys[owner]
+            if !ok {
+                continue
+            }
+
+            found := false
+            for _, okey := range okeys {
+                if okey.PublicKey == key.PublicKey {
+                    found = true
+                    break
+                }
+            }
+            if !found {
+                retired[origin] = key
+            }
+        }
+    }
+
+    return retired
+}
+
+// Detect if a rollover has been started by any of the signers in a group and
+// return the stage to continue with, only done if group-rollover-detect:<fqdn>
+// is set to yes
+func AutomateDetectRollover(fqdn string, output *[]string) (string, error) {
+    if Config.Get("group-rollover-detect:"+fqdn, "") != "yes" {
+        return "", nil
+    }
+    if len(Config.ListGet("signers:"+fqdn)) < 2 {
+        return "", nil
+    }
+
+    dnskeys, err := GroupDnskeys(fqdn)
+    if err != nil {
+        return "", err
+    }
+
+    for signer, keys := range dnskeys {
+        if Config.Get("signer-leaving:"+signer, "") != "" {
+            continue
+        }
+        for _, key := range keys {
+            if f := key.Flags & 0x101; f != 256 {
+                continue
+            }
+            if !Config.Exists("dnskey-origin:" + fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey)) {
+                *output = append(*output, fmt.Sprintf("New ZSK %d in %s", key.KeyTag(), signer))
+                return AutomateRolloverZskSyncDnskeys, nil
+            }
+        }
+    }
+
+    if retired := RetiredZsks(dnskeys); len(retired) > 0 {
+        for _, key := range retired {
+            *output = append(*output, fmt.Sprintf("Retired ZSK %d", key.KeyTag()))
+        }
+        return AutomateRolloverZskRetired, nil
+    }
+
+    holder := GroupKskSigner(fqdn)
+    for signer, keys := range dnskeys {
+        if Config.Get("signer-leaving:"+signer, "") != "" {
+            continue
+        }
+        // in Model 1 the other signers publish the KSKs of the KSK signer
+        if holder != "" && signer != holder {
+            continue
+        }
+
+        // KSKs of signers that has no known KSKs are recorded, this is done
+        // for groups created before KSK origins was tracked
+        known := false
+        for _, k := range Config.PrefixKeys("ksk-origin:") {
+            if Config.Get(k, "") == signer {
+                known = true
+                break
+            }
+        }
+
+        for _, key := range keys {
+            if f := key.Flags & 0x101; f != 257 {
+                continue
+            }
+            origin := fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey)
+            if !known {
+                Config.SetIfNotExists("ksk-origin:"+origin, signer)
+                continue
+            }
+            if !Config.Exists("ksk-origin:" + origin) {
+                *output = append(*output, fmt.Sprintf("New KSK %d in %s", key.KeyTag(), signer))
+                return AutomateRolloverKskSyncCdscdnskeys, nil
+            }
+        }
+    }
+
+    if retired := RetiredKsks(dnskeys); len(retired) > 0 {
+        for origin, _ := range retired {
+            *output = append(*output, fmt.Sprintf("Retired KSK %s", origin))
+        }
+        return AutomateRolloverKskRetired, nil
+    }
+
+    return "", nil
+}
+
+// Check that a signer has retired a ZSK, it should no longer be published
+// by its owner and the owner should no longer sign with it
+func AutomateZskRetired(fqdn string, output *[]string) (bool, error) {
+    dnskeys, err := GroupDnskeys(fqdn)
+    if err != nil {
+        return false, err
+    }
+
+    retired := RetiredZsks(dnskeys)
+    if len(retired) == 0 {
+        *output = append(*output, "No ZSK retired yet for "+fqdn)
+        return false, nil
+    }
+
+    for origin, key := range retired {
+        owner := Config.Get("dnskey-origin:"+origin, "")
+        ip := Config.Get("signer:"+owner, "")
+        if ip == "" {
+            return false, fmt.Errorf("No ip|host for signer %s", owner)
+        }
+
+        for _, qtype := range []uint16{dns.TypeSOA, dns.TypeDNSKEY, dns.TypeNS} {
+            r, err := Query(ip, owner, fqdn, qtype)
+            if err != nil {
+                return false, err
+            }
+            for _, a := range r.Answer {
+                rrsig, ok := a.(*dns.RRSIG)
+                if !ok {
+                    continue
+                }
+                if rrsig.KeyTag == key.KeyTag() && rrsig.Algorithm == key.Algorithm {
+                    *output = append(*output, fmt.Sprintf("Retired ZSK %d still used for signing by %s", key.KeyTag(), owner))
+                    return false, nil
+                }
+            }
+        }
+
+        *output = append(*output, fmt.Sprintf("ZSK %d retired by %s", key.KeyTag(), owner))
+    }
+
+    return true, nil
+}
+
+// Check that retired ZSKs have been removed from all signers
+func AutomateZsksRemoved(fqdn string, output *[]string) (bool, error) {
+    dnskeys, err := GroupDnskeys(fqdn)
+    if err != nil {
+        return false, err
+    }
+
+    retired := RetiredZsks(dnskeys)
+    for _, key := range retired {
+        *output = append(*output, fmt.Sprintf("Retired ZSK %d not removed yet for %s", key.KeyTag(), fqdn))
+    }
+    if len(retired) > 0 {
+        return false, nil
+    }
+
+    // forget the origin of keys that no signer in the group publish anymore
+    published := make(map[string]bool)
+    for _, keys := range dnskeys {
+        for _, key := range keys {
+            published[fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey)] = true
+        }
+    }
+    for _, k := range Config.PrefixKeys("dnskey-origin:") {
+        if _, ok := dnskeys[Config.Get(k, "")]; !ok {
+            continue
+        }
+        if !published[strings.TrimPrefix(k, "dnskey-origin:")] {
+            Config.Remove(k)
+        }
+    }
+
+    return true, nil
+}
+
+// Return the KSKs that have been retired, these are KSKs recorded in
+// ksk-origin that are no longer published by their signer. The signers
+// are mapped by the ksk-origin name.
+func RetiredKsks(dnskeys map[string][]*dns.DNSKEY) map[string]string {
+    retired := make(map[string]string)
+
+    for _, k := range Config.PrefixKeys("ksk-origin:") {
+        owner := Config.Get(k, "")
+        keys, ok := dnskeys[owner]
+        if !ok {
+            continue
+        }
+        if Config.Get("signer-leaving:"+owner, "") != "" {
+            continue
+        }
+
+        origin := strings.TrimPrefix(k, "ksk-origin:")
+        found := false
+        for _, key := range keys {
+            if f := key.Flags & 0x101; f == 257 && fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey) == origin {
+                found = true
+                break
+            }
+        }
+        if !found {
+            retired[origin] = owner
+        }
+    }
+
+    return retired
+}
+
+// Check that a signer has retired its old KSK
+func AutomateKskRetired(fqdn string, output *[]string) (bool, error) {
+    dnskeys, err := GroupDnskeys(fqdn)
+    if err != nil {
+        return false, err
+    }
+
+    retired := RetiredKsks(dnskeys)
+    if len(retired) == 0 {
+        *output = append(*output, "No KSK retired yet for "+fqdn)
+        return false, nil
+    }
+
+    for _, owner := range retired {
+        *output = append(*output, fmt.Sprintf("KSK retired by %s", owner))
+    }
+
+    return true, nil
+}
+
+// Forget the origin of retired KSKs once their DS has been removed
+func rolloverForgetRetiredKsks(args []string, remote bool, output *[]string) error {
+    dnskeys, err := GroupDnskeys(args[0])
+    if err != nil {
+        return err
+    }
+
+    for origin, owner := range RetiredKsks(dnskeys) {
+        Config.Remove("ksk-origin:" + origin)
+        *output = append(*output, fmt.Sprintf("Forgot retired KSK of %s", owner))
+    }
+
+    return nil
+}
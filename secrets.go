@@ -0,0 +1,390 @@
+package main
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+
+    "golang.org/x/crypto/pbkdf2"
+)
+
+// Config keys holding secrets, they are kept by the SecretProvider and not in
+// the config file
+var SecretPrefixes = []string{"tsigkey-", "desectoken-", "daemon-token:"}
+
+// The environment variable with the passphrase of an encrypted secret file
+const SecretPassphraseEnv = "MSC_SECRET_PASSPHRASE"
+
+// The prefix of environment variables used by the env secret provider
+const SecretEnvPrefix = "MSC_SECRET_"
+
+// Shown instead of the value of a secret
+const SecretRedacted = "<redacted>"
+
+// Stores secrets by their config key (tsigkey-<name> etc)
+type SecretProvider interface {
+    // Return the secret, empty if it does not exist
+    Get(key string) (string, error)
+    // Set or, if value is empty, remove a secret
+    Set(key, value string) error
+    // Return the keys of all secrets with a prefix
+    Keys(prefix string) ([]string, error)
+}
+
+// The secret provider in use, set up by LoadSecrets
+var Secrets SecretProvider
+
+// Check if a config key holds a secret
+func IsSecret(key string) bool {
+    for _, p := range SecretPrefixes {
+        if strings.HasPrefix(key, p) {
+            return true
+        }
+    }
+    return false
+}
+
+// Return the arguments of a command with the values of secrets redacted so
+// they can be logged, conf-set is the only command given a secret
+func RedactArgs(args []string) []string {
+    if len(args) > 2 && args[0] == "conf-set" && IsSecret(args[1]) {
+        return []string{args[0], args[1], SecretRedacted}
+    }
+    return args
+}
+
+// Set up the secret provider configured with secret-provider and move any
+// secrets still in the config into it. The providers are:
+//
+//    file       a JSON file with mode 0600, secret-file or <conf>.secrets (default)
+//    env        environment variables MSC_SECRET_<KEY>, read only
+//    encrypted  an AES-GCM encrypted file, secret-file or <conf>.secrets.enc,
+//               unlocked by the passphrase in MSC_SECRET_PASSPHRASE or the
+//               file in secret-passphrase-file
+func LoadSecrets(conf string) error {
+    switch provider := Config.Get("secret-provider", "file"); provider {
+    case "file":
+        Secrets = &fileSecrets{file: Config.Get("secret-file", conf+".secrets")}
+    case "env":
+        Secrets = envSecrets{}
+    case "encrypted":
+        passphrase, err := secretPassphrase()
+        if err != nil {
+            return err
+        }
+        Secrets = &fileSecrets{file: Config.Get("secret-file", conf+".secrets.enc"), passphrase: passphrase}
+    default:
+        return fmt.Errorf("Unknown secret-provider %s", provider)
+    }
+
+    // Make sure the secrets can be read, e.g. that the passphrase is correct
+    if _, err := Secrets.Keys(""); err != nil {
+        return err
+    }
+
+    return MoveSecrets()
+}
+
+// Move secrets found in the config to the secret provider, the config is
+// stored without them and they are removed from the backups of the config
+func MoveSecrets() error {
+    TsigMigrateAlgorithms()
+
+    moved := 0
+    for _, key := range Config.PrefixKeys("") {
+        if !IsSecret(key) {
+            continue
+        }
+        if err := Secrets.Set(key, Config.Get(key, "")); err != nil {
+            return fmt.Errorf("moving %s from config to secret provider: %s", key, err)
+        }
+        Config.Remove(key)
+        log.Printf("Moved secret %s from config to secret provider", key)
+        moved++
+    }
+    if moved == 0 {
+        return nil
+    }
+
+    if err := Config.Store(); err != nil {
+        return err
+    }
+    if err := Config.ScrubSecrets(); err != nil {
+        return fmt.Errorf("removing secrets from config backups: %s", err)
+    }
+    return nil
+}
+
+func secretPassphrase() (string, error) {
+    if p := os.Getenv(SecretPassphraseEnv); p != "" {
+        return p, nil
+    }
+    if file := Config.Get("secret-passphrase-file", ""); file != "" {
+        b, err := ioutil.ReadFile(file)
+        if err != nil {
+            return "", err
+        }
+        return strings.TrimSpace(string(b)), nil
+    }
+    return "", fmt.Errorf("Encrypted secrets require %s or secret-passphrase-file", SecretPassphraseEnv)
+}
+
+// Return a secret, empty if it does not exist or can not be read
+func GetSecret(key string) string {
+    if Secrets == nil {
+        return Config.Get(key, "")
+    }
+    v, err := Secrets.Get(key)
+    if err != nil {
+        log.Printf("Secret %s: %s", key, err)
+        return ""
+    }
+    return v
+}
+
+// Set or, if value is empty, remove a secret
+func SetSecret(key, value string) error {
+    if Secrets == nil {
+        if value == "" {
+            Config.Remove(key)
+        } else {
+            Config.Set(key, value)
+        }
+        return nil
+    }
+    if err := Secrets.Set(key, value); err != nil {
+        return err
+    }
+    Config.AuditSecret(key, value == "")
+    return nil
+}
+
+// Return the keys of all secrets with a prefix
+func SecretKeys(prefix string) []string {
+    if Secrets == nil {
+        return Config.PrefixKeys(prefix)
+    }
+    keys, err := Secrets.Keys(prefix)
+    if err != nil {
+        log.Printf("Secrets: %s", err)
+        return []string{}
+    }
+    return keys
+}
+
+// Secrets kept in a JSON file with mode 0600, encrypted if a passphrase is
+// given. The file is read once, as the config file lock keeps other
+// processes from changing it.
+type fileSecrets struct {
+    m          sync.Mutex
+    file       string
+    passphrase string
+    secrets    map[string]string
+}
+
+func (f *fileSecrets) load() (map[string]string, error) {
+    if f.secrets != nil {
+        return f.secrets, nil
+    }
+    secrets := make(map[string]string)
+
+    b, err := ioutil.ReadFile(f.file)
+    if os.IsNotExist(err) {
+        return secrets, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    if f.passphrase != "" {
+        if b, err = secretDecrypt(b, f.passphrase); err != nil {
+            return nil, fmt.Errorf("%s: %s", f.file, err)
+        }
+    }
+
+    if err := json.Unmarshal(b, &secrets); err != nil {
+        return nil, fmt.Errorf("%s: %s", f.file, err)
+    }
+    f.secrets = secrets
+    return secrets, nil
+}
+
+func (f *fileSecrets) Get(key string) (string, error) {
+    f.m.Lock()
+    defer f.m.Unlock()
+
+    secrets, err := f.load()
+    if err != nil {
+        return "", err
+    }
+    return secrets[key], nil
+}
+
+func (f *fileSecrets) Set(key, value string) error {
+    f.m.Lock()
+    defer f.m.Unlock()
+
+    current, err := f.load()
+    if err != nil {
+        return err
+    }
+    secrets := make(map[string]string)
+    for k, v := range current {
+        secrets[k] = v
+    }
+    if value == "" {
+        delete(secrets, key)
+    } else {
+        secrets[key] = value
+    }
+
+    b, err := json.Marshal(secrets)
+    if err != nil {
+        return err
+    }
+    if f.passphrase != "" {
+        if b, err = secretEncrypt(b, f.passphrase); err != nil {
+            return err
+        }
+    }
+
+    if err := writeConfigFile(f.file, b, 0600, 0); err != nil {
+        return err
+    }
+    f.secrets = secrets
+    return nil
+}
+
+func (f *fileSecrets) Keys(prefix string) ([]string, error) {
+    f.m.Lock()
+    defer f.m.Unlock()
+
+    secrets, err := f.load()
+    if err != nil {
+        return nil, err
+    }
+    keys := []string{}
+    for k := range secrets {
+        if strings.HasPrefix(k, prefix) {
+            keys = append(keys, k)
+        }
+    }
+    sort.Strings(keys)
+    return keys, nil
+}
+
+// Secrets read from the environment, the key is upper cased with - and :
+// replaced by _ so tsigkey-signer1 is read from MSC_SECRET_TSIGKEY_SIGNER1
+type envSecrets struct{}
+
+func envSecretName(key string) string {
+    return SecretEnvPrefix + strings.Map(func(r rune) rune {
+        if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+            return r
+        }
+        return '_'
+    }, strings.ToUpper(key))
+}
+
+func (e envSecrets) Get(key string) (string, error) {
+    return os.Getenv(envSecretName(key)), nil
+}
+
+func (e envSecrets) Set(key, value string) error {
+    return fmt.Errorf("secrets can not be set with secret-provider env, set %s instead", envSecretName(key))
+}
+
+// As the variable names do not keep - or : the keys are only known for
+// prefixes, e.g. MSC_SECRET_DAEMON_TOKEN_ADMIN is returned as
+// daemon-token:admin
+func (e envSecrets) Keys(prefix string) ([]string, error) {
+    env := envSecretName(prefix)
+    keys := []string{}
+    for _, kv := range os.Environ() {
+        i := strings.IndexByte(kv, '=')
+        if i < 0 || kv[:i] == SecretPassphraseEnv || !strings.HasPrefix(kv[:i], env) {
+            continue
+        }
+        keys = append(keys, prefix+strings.ToLower(kv[len(env):i]))
+    }
+    sort.Strings(keys)
+    return keys, nil
+}
+
+// Parameters of the encrypted secret file: salt, iterations of PBKDF2 with
+// HMAC-SHA256 and the AES-256-GCM nonce are stored before the ciphertext.
+// Files with fewer iterations than secretMinIterations are refused, the
+// iterations are read before the header can be authenticated.
+const secretSaltSize = 16
+const secretIterations = 200000
+const secretMinIterations = 100000
+
+// Derive the AES-256 key from the passphrase
+func secretKey(passphrase string, salt []byte, iterations int) []byte {
+    return pbkdf2.Key([]byte(passphrase), salt, iterations, 32, sha256.New)
+}
+
+func secretEncrypt(data []byte, passphrase string) ([]byte, error) {
+    salt := make([]byte, secretSaltSize)
+    if _, err := rand.Read(salt); err != nil {
+        return nil, err
+    }
+    block, err := aes.NewCipher(secretKey(passphrase, salt, secretIterations))
+    if err != nil {
+        return nil, err
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    nonce := make([]byte, gcm.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+
+    out := append([]byte{}, salt...)
+    out = append(out, make([]byte, 4)...)
+    binary.BigEndian.PutUint32(out[secretSaltSize:], secretIterations)
+    out = append(out, nonce...)
+    return gcm.Seal(out, nonce, data, append([]byte{}, out...)), nil
+}
+
+func secretDecrypt(data []byte, passphrase string) ([]byte, error) {
+    if len(data) < secretSaltSize+4 {
+        return nil, fmt.Errorf("encrypted secrets too short")
+    }
+    salt := data[:secretSaltSize]
+    iterations := int(binary.BigEndian.Uint32(data[secretSaltSize:]))
+    if iterations < secretMinIterations || iterations > 100*secretIterations {
+        return nil, fmt.Errorf("invalid iterations in encrypted secrets")
+    }
+
+    block, err := aes.NewCipher(secretKey(passphrase, salt, iterations))
+    if err != nil {
+        return nil, err
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+
+    headerSize := secretSaltSize + 4 + gcm.NonceSize()
+    if len(data) < headerSize {
+        return nil, fmt.Errorf("encrypted secrets too short")
+    }
+    plain, err := gcm.Open(nil, data[secretSaltSize+4:headerSize], data[headerSize:], data[:headerSize])
+    if err != nil {
+        return nil, fmt.Errorf("can not decrypt secrets, wrong passphrase?")
+    }
+    return plain, nil
+}
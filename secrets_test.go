@@ -0,0 +1,193 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/hex"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// PBKDF2-HMAC-SHA256 test vectors from RFC 7914 section 11, secretKey
+// returns the first 32 bytes of the derived key
+func TestSecretKey(t *testing.T) {
+    tests := []struct {
+        passphrase, salt string
+        iterations       int
+        key              string
+    }{
+        {"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+        {"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+    }
+
+    for _, test := range tests {
+        key := hex.EncodeToString(secretKey(test.passphrase, []byte(test.salt), test.iterations))
+        if key != test.key {
+            t.Errorf("secretKey(%q, %q, %d) = %s, expected %s", test.passphrase, test.salt, test.iterations, key, test.key)
+        }
+    }
+}
+
+func TestSecretEncrypt(t *testing.T) {
+    plain := []byte(`{"tsigkey-k1":"c2VjcmV0"}`)
+
+    enc, err := secretEncrypt(plain, "passphrase")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if bytes.Contains(enc, plain) {
+        t.Fatal("plain text found in encrypted secrets")
+    }
+
+    dec, err := secretDecrypt(enc, "passphrase")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(dec, plain) {
+        t.Errorf("decrypted %q, expected %q", dec, plain)
+    }
+
+    // salt and nonce are random
+    again, err := secretEncrypt(plain, "passphrase")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if bytes.Equal(enc, again) {
+        t.Error("encrypting twice gave the same result")
+    }
+
+    if _, err := secretDecrypt(enc, "wrong"); err == nil {
+        t.Error("decrypted with the wrong passphrase")
+    }
+
+    // the header (salt and iterations) is authenticated as well as the
+    // ciphertext
+    for _, i := range []int{0, secretSaltSize + 3, len(enc) - 1} {
+        tampered := append([]byte{}, enc...)
+        tampered[i] ^= 1
+        if _, err := secretDecrypt(tampered, "passphrase"); err == nil {
+            t.Errorf("decrypted with byte %d changed", i)
+        }
+    }
+
+    if _, err := secretDecrypt(enc[:secretSaltSize+4+5], "passphrase"); err == nil {
+        t.Error("decrypted truncated secrets")
+    }
+
+    // fewer iterations are refused before deriving the key
+    weak := append([]byte{}, enc...)
+    binary.BigEndian.PutUint32(weak[secretSaltSize:], secretMinIterations-1)
+    if _, err := secretDecrypt(weak, "passphrase"); err == nil || !strings.Contains(err.Error(), "iterations") {
+        t.Errorf("decrypted with %d iterations: %v", secretMinIterations-1, err)
+    }
+}
+
+func TestFileSecrets(t *testing.T) {
+    for _, passphrase := range []string{"", "passphrase"} {
+        file := filepath.Join(t.TempDir(), "secrets")
+
+        s := &fileSecrets{file: file, passphrase: passphrase}
+        if err := s.Set("tsigkey-k1", "c2VjcmV0"); err != nil {
+            t.Fatal(err)
+        }
+        if err := s.Set("daemon-token:admin", "token"); err != nil {
+            t.Fatal(err)
+        }
+        if err := s.Set("daemon-token:admin", ""); err != nil {
+            t.Fatal(err)
+        }
+
+        b, err := ioutil.ReadFile(file)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if passphrase != "" && bytes.Contains(b, []byte("c2VjcmV0")) {
+            t.Error("secret stored in plain text")
+        }
+
+        // read the file again
+        s = &fileSecrets{file: file, passphrase: passphrase}
+        if v, err := s.Get("tsigkey-k1"); err != nil || v != "c2VjcmV0" {
+            t.Errorf("Get returned %q, %v", v, err)
+        }
+        keys, err := s.Keys("")
+        if err != nil || len(keys) != 1 || keys[0] != "tsigkey-k1" {
+            t.Errorf("Keys returned %v, %v", keys, err)
+        }
+    }
+}
+
+func TestIsSecret(t *testing.T) {
+    tests := map[string]bool{
+        "tsigkey-k1":                true,
+        "tsigkey-algorithm-k1":      true,
+        "desectoken-t1":             true,
+        "daemon-token:admin":        true,
+        "tsig-algorithm:k1":         false,
+        "signer-tsigkey:s1":         false,
+        "daemon-tls-cert":           false,
+        "daemon-allow-anonymous":    false,
+        "secret-passphrase-file":    false,
+        "group-automate-interval:x": false,
+    }
+
+    for key, secret := range tests {
+        if IsSecret(key) != secret {
+            t.Errorf("IsSecret(%q) = %v", key, !secret)
+        }
+    }
+}
+
+func TestRedactArgs(t *testing.T) {
+    redacted := RedactArgs([]string{"conf-set", "tsigkey-k1", "c2VjcmV0"})
+    if redacted[2] != SecretRedacted {
+        t.Errorf("secret not redacted: %v", redacted)
+    }
+
+    args := []string{"conf-set", "tsig-algorithm:k1", "hmac-sha512"}
+    if redacted := RedactArgs(args); redacted[2] != args[2] {
+        t.Errorf("value redacted: %v", redacted)
+    }
+}
+
+func TestTsigMigrateAlgorithms(t *testing.T) {
+    previous := Config
+    t.Cleanup(func() { Config = previous })
+    Config = NewConfig()
+    Config.Set("tsigkey-algorithm-k1", "hmac-sha512")
+    // the secret of a key named algorithm-k2
+    Config.Set("tsigkey-algorithm-k2", "c2VjcmV0")
+
+    TsigMigrateAlgorithms()
+
+    if v := Config.Get("tsig-algorithm:k1", ""); v != "hmac-sha512" {
+        t.Errorf("tsig-algorithm:k1 is %q", v)
+    }
+    if Config.Exists("tsigkey-algorithm-k1") {
+        t.Error("tsigkey-algorithm-k1 not removed")
+    }
+    if v := Config.Get("tsigkey-algorithm-k2", ""); v != "c2VjcmV0" {
+        t.Errorf("secret of key algorithm-k2 changed to %q", v)
+    }
+    if Config.Exists("tsig-algorithm:k2") {
+        t.Error("secret moved as algorithm")
+    }
+}
+
+func TestTsigAlgorithmKey(t *testing.T) {
+    tests := []struct{ key, value, expected string }{
+        {"tsigkey-algorithm-k1", "hmac-sha512", "tsig-algorithm:k1"},
+        {"tsigkey-algorithm-k1", "HMAC-SHA384.", "tsig-algorithm:k1"},
+        {"tsigkey-algorithm-k2", "c2VjcmV0", "tsigkey-algorithm-k2"},
+        {"tsigkey-k1", "hmac-sha512", "tsigkey-k1"},
+        {"tsig-algorithm:k1", "hmac-sha512", "tsig-algorithm:k1"},
+    }
+
+    for _, test := range tests {
+        if key := TsigAlgorithmKey(test.key, test.value); key != test.expected {
+            t.Errorf("TsigAlgorithmKey(%q, %q) = %q, expected %q", test.key, test.value, key, test.expected)
+        }
+    }
+}
@@ -6,7 +6,7 @@ import (
 
 func init() {
     Command["signer-add"] = SignerAddCmd
-    Command["signer-list"] = SignerListCmd
+    Command["signer-list"] = CmdOutput(SignerListCmd)
     Command["signer-remove"] = SignerRemoveCmd
     Command["signer-tsig"] = SignerTsigCmd
     Command["signer-mark-leave"] = SignerMarkLeaveCmd
@@ -18,6 +18,11 @@ func init() {
     CommandHelp["signer-tsig"] = "Set or show which TSIG key to use for dynamic updates, requires <name> [TSIG key]"
     CommandHelp["signer-mark-leave"] = "Mark a signer that it's leaving the group"
     CommandHelp["signer-unmark-leave"] = "Unmark a signer that's leaving the group"
+
+    CommandResult["signer-list"] = SignerListCmd
+    // signer names are unique across all groups
+    CommandGlobal["signer-add"] = true
+    CommandGlobal["signer-remove"] = true
 }
 
 func SignerAddCmd(args []string, remote bool, output *[]string) error {
@@ -53,27 +58,43 @@ func SignerAddCmd(args []string, remote bool, output *[]string) error {
     if stage != AutomateManual {
         l := Config.ListGet("signers:" + args[0])
         if len(l) > 1 {
-            Config.Set("automate-stage:"+args[0], AutomateJoinSyncDnskeys)
-            *output = append(*output, fmt.Sprintf("Automation for %s now %s", args[0], AutomateJoinSyncDnskeys))
+            AutomateSetStage(args[0], AutomateJoinPreflight)
+            *output = append(*output, fmt.Sprintf("Automation for %s now %s", args[0], AutomateJoinPreflight))
         }
     }
 
     return nil
 }
 
-func SignerListCmd(args []string, remote bool, output *[]string) error {
+type signerListEntry struct {
+    Name    string `json:"name"`
+    Address string `json:"address"`
+    NS      string `json:"ns"`
+    Leaving bool   `json:"leaving"`
+}
+
+func SignerListCmd(args []string, remote bool, result *CmdResult) error {
     if len(args) < 1 {
         return fmt.Errorf("requires <group>")
     }
 
-    l := Config.ListGet("signers:" + args[0])
-    *output = append(*output, fmt.Sprintf("Signers in %s:", args[0]))
-    for _, v := range l {
-        ip := Config.Get("signer:"+v, "")
-        *output = append(*output, fmt.Sprintf("  %s %s", v, ip))
+    group, err := Config.Group(args[0])
+    if err != nil {
+        return err
     }
 
-    return nil
+    signers := []signerListEntry{}
+    result.Output = append(result.Output, fmt.Sprintf("Signers in %s:", args[0]))
+    for _, v := range group.Signers {
+        signer, err := Config.Signer(v)
+        if err != nil {
+            return err
+        }
+        result.Output = append(result.Output, fmt.Sprintf("  %s %s", v, signer.Address))
+        signers = append(signers, signerListEntry{v, signer.Address, signer.NS, signer.Leaving != ""})
+    }
+
+    return result.SetData(signers)
 }
 
 func SignerRemoveCmd(args []string, remote bool, output *[]string) error {
@@ -111,6 +132,11 @@ func SignerRemoveCmd(args []string, remote bool, output *[]string) error {
             Config.Remove(k)
         }
     }
+    for _, k := range Config.PrefixKeys("ksk-origin:") {
+        if Config.Get(k, "") == args[0] {
+            Config.Remove(k)
+        }
+    }
     Config.Remove("signer-leaving:" + args[0])
 
     *output = append(*output, fmt.Sprintf("Signer %s removed", args[0]))
@@ -128,8 +154,8 @@ func SignerTsigCmd(args []string, remote bool, output *[]string) error {
     }
 
     if len(args) > 1 {
-        if !Config.Exists("tsigkey-" + args[1]) {
-            return fmt.Errorf("TSIG key does not exist, use conf-set tsigkey-%s <secret>", args[1])
+        if GetSecret("tsigkey-"+args[1]) == "" {
+            return fmt.Errorf("TSIG key does not exist, use tsig-import <file> or conf-set tsigkey-%s <secret>", args[1])
         }
 
         Config.Set("signer-tsigkey:"+args[0], args[1])
@@ -169,13 +195,17 @@ func SignerMarkLeaveCmd(args []string, remote bool, output *[]string) error {
         return fmt.Errorf("group %s is not ready for change (automate stage %s)", group, stage)
     }
 
+    if GroupKskSigner(group) == args[0] {
+        return fmt.Errorf("signer %s holds the KSK of model 1 group %s, change the model or KSK signer first", args[0], group)
+    }
+
     Config.Set("signer-leaving:"+args[0], "yes")
     *output = append(*output, fmt.Sprintf("Signer %s now marked as leaving", args[0]))
 
     if stage != AutomateManual {
         l := Config.ListGet("signers:" + group)
         if len(l) > 1 {
-            Config.Set("automate-stage:"+group, AutomateLeaveSyncNses)
+            AutomateSetStage(group, AutomateLeaveSyncNses)
             *output = append(*output, fmt.Sprintf("Automation for %s now %s", group, AutomateLeaveSyncNses))
         }
     }
@@ -212,8 +242,8 @@ func SignerUnmarkLeaveCmd(args []string, remote bool, output *[]string) error {
     if stage != AutomateManual {
         l := Config.ListGet("signers:" + group)
         if len(l) > 1 {
-            Config.Set("automate-stage:"+group, AutomateJoinSyncDnskeys)
-            *output = append(*output, fmt.Sprintf("Automation for %s now %s", group, AutomateJoinSyncDnskeys))
+            AutomateSetStage(group, AutomateJoinPreflight)
+            *output = append(*output, fmt.Sprintf("Automation for %s now %s", group, AutomateJoinPreflight))
         }
     }
 
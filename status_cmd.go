@@ -1,138 +1,258 @@
 package main
 
 import (
+    "encoding/json"
     "fmt"
 
     "github.com/miekg/dns"
 )
 
 func init() {
-    Command["status"] = StatusCmd
+    Command["status"] = CmdOutput(StatusCmd)
+    Command["status-dnskeys"] = StatusCheckCmd(StatusDnskeys)
+    Command["status-cdscdnskeys"] = StatusCheckCmd(StatusCdscdnskeys)
+    Command["status-ns"] = StatusCheckCmd(StatusNses)
+    Command["status-parent-ds"] = StatusCheckCmd(StatusParentDs)
+    Command["status-parent-ns"] = StatusCheckCmd(StatusParentNs)
+
+    CommandResult["status"] = StatusCmd
+    CommandResult["status-dnskeys"] = StatusCheckResult(StatusDnskeys)
+    CommandResult["status-cdscdnskeys"] = StatusCheckResult(StatusCdscdnskeys)
+    CommandResult["status-ns"] = StatusCheckResult(StatusNses)
+    CommandResult["status-parent-ds"] = StatusCheckResult(StatusParentDs)
+    CommandResult["status-parent-ns"] = StatusCheckResult(StatusParentNs)
 
     CommandHelp["status"] = "Check status of a signer group, requires <fqdn>"
+    CommandHelp["status-dnskeys"] = "Check that the DNSKEYs of a signer group are in sync, requires <fqdn>"
+    CommandHelp["status-cdscdnskeys"] = "Check that the CDS/CDNSKEYs of a signer group are in sync, requires <fqdn>"
+    CommandHelp["status-ns"] = "Check that the NSes of a signer group are in sync, requires <fqdn>"
+    CommandHelp["status-parent-ds"] = "Check that the parent DS of a signer group is in sync, requires <fqdn>"
+    CommandHelp["status-parent-ns"] = "Check that the parent NS of a signer group is in sync, requires <fqdn>"
 }
 
-func StatusCmd(args []string, remote bool, output *[]string) error {
-    //
-    // This function checks the status of all steps and can be a bit
-    // misleading when reporting missing or needs removal for things
-    // that shouldn't be done. But the relevant states for each automation
-    // stage is correct.
-    //
-    // TODO: could be split into many different functions that only checks
-    //       a specific state
-    //
+// The name used in results for records checked in the parent
+const StatusParent = "parent"
+
+// The result of a status check, the records that are missing or needs
+// removal are mapped by the signer they were checked in (or StatusParent).
+// Invalid holds other reasons for a signer not being in sync, such as
+// signatures that fail verification.
+type StatusResult struct {
+    Missing map[string][]dns.RR
+    Extra   map[string][]dns.RR
+    Invalid map[string][]string
+}
 
-    if len(args) < 1 {
-        return fmt.Errorf("requires <fqdn>")
+// A status check of a group, sets the group-*-synced:<fqdn> flag it covers
+type StatusCheck func(fqdn string, output *[]string) (*StatusResult, error)
+
+func NewStatusResult() *StatusResult {
+    return &StatusResult{
+        Missing: make(map[string][]dns.RR),
+        Extra:   make(map[string][]dns.RR),
+        Invalid: make(map[string][]string),
     }
+}
 
-    if !Config.Exists("signers:" + args[0]) {
-        return fmt.Errorf("group %s has no signers", args[0])
+func (r *StatusResult) AddMissing(signer string, rr dns.RR) {
+    if !rrContains(r.Missing[signer], rr) {
+        r.Missing[signer] = append(r.Missing[signer], rr)
     }
+}
 
-    signers := Config.ListGet("signers:" + args[0])
+func (r *StatusResult) AddExtra(signer string, rr dns.RR) {
+    if !rrContains(r.Extra[signer], rr) {
+        r.Extra[signer] = append(r.Extra[signer], rr)
+    }
+}
 
-    dnskeys := make(map[string][]*dns.DNSKEY)
-    cdnskeys := make(map[string][]*dns.CDNSKEY)
-    cdses := make(map[string][]*dns.CDS)
-    nses := make(map[string][]*dns.NS)
+func (r *StatusResult) AddInvalid(signer, reason string) {
+    r.Invalid[signer] = append(r.Invalid[signer], reason)
+}
 
-    for _, signer := range signers {
-        ip := Config.Get("signer:"+signer, "")
-        if ip == "" {
-            return fmt.Errorf("No ip|host for signer %s", signer)
+func (r *StatusResult) Synced() bool {
+    return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Invalid) == 0
+}
+
+// Records are encoded in presentation format
+func (r *StatusResult) MarshalJSON() ([]byte, error) {
+    rrs := func(m map[string][]dns.RR) map[string][]string {
+        s := make(map[string][]string)
+        for signer, rrs := range m {
+            for _, rr := range rrs {
+                s[signer] = append(s[signer], rr.String())
+            }
         }
+        return s
+    }
 
-        m := new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeDNSKEY)
+    return json.Marshal(struct {
+        Synced  bool                `json:"synced"`
+        Missing map[string][]string `json:"missing"`
+        Extra   map[string][]string `json:"extra"`
+        Invalid map[string][]string `json:"invalid"`
+    }{r.Synced(), rrs(r.Missing), rrs(r.Extra), r.Invalid})
+}
 
-        c := new(dns.Client)
-        r, _, err := c.Exchange(m, ip)
+// Return a command that runs a single status check
+func StatusCheckCmd(check StatusCheck) CmdFunc {
+    return CmdOutput(StatusCheckResult(check))
+}
+
+// Same as StatusCheckCmd but the result of the check is also returned as data
+func StatusCheckResult(check StatusCheck) CmdResultFunc {
+    return func(args []string, remote bool, result *CmdResult) error {
+        if len(args) < 1 {
+            return fmt.Errorf("requires <fqdn>")
+        }
 
+        status, err := check(args[0], &result.Output)
         if err != nil {
             return err
         }
 
-        dnskeys[signer] = []*dns.DNSKEY{}
+        if status.Synced() {
+            result.Output = append(result.Output, "In sync")
+        } else {
+            result.Output = append(result.Output, "Not in sync")
+        }
 
-        for _, a := range r.Answer {
-            dnskey, ok := a.(*dns.DNSKEY)
-            if !ok {
-                continue
-            }
+        return result.SetData(status)
+    }
+}
 
-            owner := Config.Get("dnskey-origin:"+fmt.Sprintf("%d-%d-%s", dnskey.Protocol, dnskey.Algorithm, dnskey.PublicKey), "")
-            if owner != "" {
-                owner = " (owner: " + owner + ")"
-            }
+func StatusCmd(args []string, remote bool, result *CmdResult) error {
+    //
+    // This function runs all the status checks and can be a bit misleading
+    // when reporting missing or needs removal for things that shouldn't be
+    // done. The automation stages only run the check they need.
+    //
 
-            *output = append(*output, fmt.Sprintf("%s: found DNSKEY %d %d %d %s%s", signer, dnskey.Flags, dnskey.Protocol, dnskey.Algorithm, dnskey.PublicKey, owner))
+    if len(args) < 1 {
+        return fmt.Errorf("requires <fqdn>")
+    }
 
-            dnskeys[signer] = append(dnskeys[signer], dnskey)
-        }
+    checks := []struct {
+        name  string
+        check StatusCheck
+    }{
+        {"dnskeys", StatusDnskeys},
+        {"cdscdnskeys", StatusCdscdnskeys},
+        {"ns", StatusNses},
+        {"parent-ds", StatusParentDs},
+        {"parent-ns", StatusParentNs},
+    }
 
-        m = new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeCDS)
-        r, _, err = c.Exchange(m, ip)
+    data := make(map[string]*StatusResult)
+    for _, c := range checks {
+        status, err := c.check(args[0], &result.Output)
         if err != nil {
             return err
         }
-        cdses[signer] = []*dns.CDS{}
-        for _, a := range r.Answer {
-            cds, ok := a.(*dns.CDS)
-            if !ok {
-                continue
-            }
+        data[c.name] = status
+    }
 
-            *output = append(*output, fmt.Sprintf("%s: found CDS %d %d %d %s", signer, cds.KeyTag, cds.Algorithm, cds.DigestType, cds.Digest))
+    return result.SetData(data)
+}
 
-            cdses[signer] = append(cdses[signer], cds)
+// Query all signers in a group
+func statusQuery(fqdn string, qtype uint16) (map[string]*dns.Msg, error) {
+    if !Config.Exists("signers:" + fqdn) {
+        return nil, fmt.Errorf("group %s has no signers", fqdn)
+    }
+
+    msgs := make(map[string]*dns.Msg)
+    for _, signer := range Config.ListGet("signers:" + fqdn) {
+        ip := Config.Get("signer:"+signer, "")
+        if ip == "" {
+            return nil, fmt.Errorf("No ip|host for signer %s", signer)
         }
 
-        m = new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeCDNSKEY)
-        r, _, err = c.Exchange(m, ip)
+        r, err := Query(ip, signer, fqdn, qtype)
         if err != nil {
-            return err
+            return nil, err
         }
-        cdnskeys[signer] = []*dns.CDNSKEY{}
-        for _, a := range r.Answer {
-            cdnskey, ok := a.(*dns.CDNSKEY)
-            if !ok {
-                continue
-            }
+        msgs[signer] = r
+    }
 
-            *output = append(*output, fmt.Sprintf("%s: found CDNSKEY %d %d %d %s", signer, cdnskey.Flags, cdnskey.Protocol, cdnskey.Algorithm, cdnskey.PublicKey))
+    return msgs, nil
+}
 
-            cdnskeys[signer] = append(cdnskeys[signer], cdnskey)
+// Return the KSKs that the CDS/CDNSKEYs of a group should be created from,
+// the KSKs of all signers that are not leaving or only those of the KSK
+// signer in Model 1
+func statusKsks(fqdn string, dnskeys map[string][]*dns.DNSKEY) []*dns.DNSKEY {
+    holder := GroupKskSigner(fqdn)
+    ksks := []*dns.DNSKEY{}
+    for signer, keys := range dnskeys {
+        if Config.Get("signer-leaving:"+signer, "") != "" {
+            continue
         }
-
-        m = new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeNS)
-        r, _, err = c.Exchange(m, ip)
-        if err != nil {
-            return err
+        if holder != "" && signer != holder {
+            continue
         }
-        nses[signer] = []*dns.NS{}
+        for _, key := range keys {
+            if f := key.Flags & 0x101; f == 257 {
+                ksks = append(ksks, key)
+            }
+        }
+    }
+    return ksks
+}
+
+// Check that the ZSKs of all signers are published by the other signers and
+// that the DNSKEY RRsets are signed by the signers KSKs, in Model 1 the
+// DNSKEY RRset of the KSK signer must also be distributed to all signers
+func StatusDnskeys(fqdn string, output *[]string) (*StatusResult, error) {
+    msgs, err := statusQuery(fqdn, dns.TypeDNSKEY)
+    if err != nil {
+        return nil, err
+    }
+
+    result := NewStatusResult()
+    dnskeys := make(map[string][]*dns.DNSKEY)
+    dnskeysets := make(map[string][]dns.RR)
+
+    for _, signer := range Config.ListGet("signers:" + fqdn) {
+        r := msgs[signer]
+
+        dnskeys[signer] = []*dns.DNSKEY{}
+        dnskeyset, rrsigs := Model1Dnskeys(r)
+        dnskeysets[signer] = append(dnskeyset, rrsigs...)
+
         for _, a := range r.Answer {
-            ns, ok := a.(*dns.NS)
+            dnskey, ok := a.(*dns.DNSKEY)
             if !ok {
                 continue
             }
 
-            owner := Config.Get("ns-origin:"+ns.Ns, "")
+            owner := Config.Get("dnskey-origin:"+fmt.Sprintf("%d-%d-%s", dnskey.Protocol, dnskey.Algorithm, dnskey.PublicKey), "")
             if owner != "" {
                 owner = " (owner: " + owner + ")"
             }
 
-            *output = append(*output, fmt.Sprintf("%s: found NS %s%s", signer, ns.Ns, owner))
+            *output = append(*output, fmt.Sprintf("%s: found DNSKEY %d %d %d %s%s", signer, dnskey.Flags, dnskey.Protocol, dnskey.Algorithm, dnskey.PublicKey, owner))
 
-            nses[signer] = append(nses[signer], ns)
+            dnskeys[signer] = append(dnskeys[signer], dnskey)
+        }
+
+        if Config.Get("signer-leaving:"+signer, "") == "" {
+            ksks := []*dns.DNSKEY{}
+            for _, key := range dnskeys[signer] {
+                if f := key.Flags & 0x101; f == 257 {
+                    ksks = append(ksks, key)
+                }
+            }
+            rrset, rrsigs := SignedRRset(r, dns.TypeDNSKEY)
+            if err := VerifyRRset(rrset, rrsigs, ksks); err != nil {
+                *output = append(*output, fmt.Sprintf("%s: DNSKEY RRset signature not valid: %s", signer, err))
+                result.AddInvalid(signer, "DNSKEY RRset signature not valid: "+err.Error())
+            }
         }
     }
 
-    group_dnskeys_synced := true
+    retired := RetiredZsks(dnskeys)
+
     for signer, keys := range dnskeys {
         if Config.Get("signer-leaving:"+signer, "") != "" {
             *output = append(*output, fmt.Sprintf("Skipping sync status of %s DNSKEYs: leaving signer", signer))
@@ -142,6 +262,10 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
 
         for _, key := range keys {
             if f := key.Flags & 0x101; f == 256 { // only process ZSK's
+                if _, ok := retired[fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey)]; ok {
+                    *output = append(*output, fmt.Sprintf("DNSKEY retired by owner, pending removal in %s: %s", signer, key.PublicKey))
+                    continue
+                }
                 for osigner, okeys := range dnskeys {
                     if osigner == signer {
                         continue
@@ -155,7 +279,7 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
                                 break
                             }
                             if okey.Algorithm != key.Algorithm {
-                                *output = append(*output, fmt.Sprintf("Found DNSKEY in %s but missmatch Protocol: %s", osigner, key.PublicKey))
+                                *output = append(*output, fmt.Sprintf("Found DNSKEY in %s but missmatch Algorithm: %s", osigner, key.PublicKey))
                                 break
                             }
                             found = true
@@ -168,36 +292,118 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
                         owner := Config.Get("dnskey-origin:"+fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey), "")
                         if owner != "" && Config.Get("signer-leaving:"+owner, "") != "" {
                             *output = append(*output, fmt.Sprintf("DNSKEY needs removal for %s: %s", osigner, key.PublicKey))
-                            group_dnskeys_synced = false
+                            result.AddExtra(osigner, key)
                         }
                     } else {
                         *output = append(*output, fmt.Sprintf("DNSKEY missing in %s: %s", osigner, key.PublicKey))
-                        group_dnskeys_synced = false
+                        result.AddMissing(osigner, key)
                     }
                 }
             }
         }
     }
-    if group_dnskeys_synced {
-        Config.Set("group-dnskeys-synced:"+args[0], "yes")
-    } else {
-        Config.Remove("group-dnskeys-synced:" + args[0])
+
+    // in Model 1 all signers must serve the DNSKEY RRset, and its RRSIGs, as
+    // signed by the KSK signer, the signers own keys and RRSIGs are not
+    // checked
+    holder := GroupKskSigner(fqdn)
+    if holder != "" {
+        *output = append(*output, fmt.Sprintf("Check DNSKEY RRset of KSK signer %s is distributed", holder))
+        for signer := range dnskeysets {
+            if signer == holder || Config.Get("signer-leaving:"+signer, "") != "" {
+                continue
+            }
+            for _, rr := range dnskeysets[holder] {
+                if !rrContains(dnskeysets[signer], rr) {
+                    *output = append(*output, fmt.Sprintf("  %s missing in %s", rr.String(), signer))
+                    result.AddMissing(signer, rr)
+                }
+            }
+            for _, rr := range dnskeysets[signer] {
+                if !rrContains(dnskeysets[holder], rr) && Model1Distributed(signer, holder, dnskeysets[holder], dnskeysets[signer], rr) {
+                    *output = append(*output, fmt.Sprintf("  %s needs removal in %s", rr.String(), signer))
+                    result.AddExtra(signer, rr)
+                }
+            }
+        }
     }
 
-    ksks := []*dns.DNSKEY{}
-    for signer, keys := range dnskeys {
-        if Config.Get("signer-leaving:"+signer, "") != "" {
-            continue
+    return result, nil
+}
+
+// Check that all signers publish CDS/CDNSKEYs for all KSKs in the group, and
+// only those, and that they are signed by the signers keys
+func StatusCdscdnskeys(fqdn string, output *[]string) (*StatusResult, error) {
+    dnskeymsgs, err := statusQuery(fqdn, dns.TypeDNSKEY)
+    if err != nil {
+        return nil, err
+    }
+    cdsmsgs, err := statusQuery(fqdn, dns.TypeCDS)
+    if err != nil {
+        return nil, err
+    }
+    cdnskeymsgs, err := statusQuery(fqdn, dns.TypeCDNSKEY)
+    if err != nil {
+        return nil, err
+    }
+
+    result := NewStatusResult()
+    signers := Config.ListGet("signers:" + fqdn)
+    dnskeys := make(map[string][]*dns.DNSKEY)
+    cdses := make(map[string][]*dns.CDS)
+    cdnskeys := make(map[string][]*dns.CDNSKEY)
+
+    for _, signer := range signers {
+        dnskeys[signer] = []*dns.DNSKEY{}
+        for _, a := range dnskeymsgs[signer].Answer {
+            if dnskey, ok := a.(*dns.DNSKEY); ok {
+                dnskeys[signer] = append(dnskeys[signer], dnskey)
+            }
         }
-        for _, key := range keys {
-            if f := key.Flags & 0x101; f == 257 {
-                ksks = append(ksks, key)
+
+        cdses[signer] = []*dns.CDS{}
+        for _, a := range cdsmsgs[signer].Answer {
+            cds, ok := a.(*dns.CDS)
+            if !ok {
+                continue
+            }
+
+            *output = append(*output, fmt.Sprintf("%s: found CDS %d %d %d %s", signer, cds.KeyTag, cds.Algorithm, cds.DigestType, cds.Digest))
+
+            cdses[signer] = append(cdses[signer], cds)
+        }
+
+        cdnskeys[signer] = []*dns.CDNSKEY{}
+        for _, a := range cdnskeymsgs[signer].Answer {
+            cdnskey, ok := a.(*dns.CDNSKEY)
+            if !ok {
+                continue
+            }
+
+            *output = append(*output, fmt.Sprintf("%s: found CDNSKEY %d %d %d %s", signer, cdnskey.Flags, cdnskey.Protocol, cdnskey.Algorithm, cdnskey.PublicKey))
+
+            cdnskeys[signer] = append(cdnskeys[signer], cdnskey)
+        }
+
+        // CDS/CDNSKEY may be signed by the KSKs or the ZSKs of a signer
+        if Config.Get("signer-leaving:"+signer, "") == "" {
+            for _, qtype := range []uint16{dns.TypeCDS, dns.TypeCDNSKEY} {
+                r := cdsmsgs[signer]
+                if qtype == dns.TypeCDNSKEY {
+                    r = cdnskeymsgs[signer]
+                }
+                rrset, rrsigs := SignedRRset(r, qtype)
+                if err := VerifyRRset(rrset, rrsigs, dnskeys[signer]); err != nil {
+                    *output = append(*output, fmt.Sprintf("%s: %s RRset signature not valid: %s", signer, dns.TypeToString[qtype], err))
+                    result.AddInvalid(signer, dns.TypeToString[qtype]+" RRset signature not valid: "+err.Error())
+                }
             }
         }
     }
 
-    group_cdscdnskeys_synced := true
-    for signer, keys := range cdses {
+    ksks := statusKsks(fqdn, dnskeys)
+
+    for _, signer := range signers {
         if Config.Get("signer-leaving:"+signer, "") != "" {
             *output = append(*output, fmt.Sprintf("Skipping sync status of %s CDSes: leaving signer", signer))
             continue
@@ -206,21 +412,35 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
 
         for _, ksk := range ksks {
             found := false
-            for _, key := range keys {
-                cds := ksk.ToDS(key.DigestType).ToCDS()
-                if cds.KeyTag == key.KeyTag && cds.Algorithm == key.Algorithm && cds.Digest == key.Digest {
+            for _, key := range cdses[signer] {
+                ds := ksk.ToDS(key.DigestType)
+                if ds != nil && ds.KeyTag == key.KeyTag && ds.Algorithm == key.Algorithm && ds.Digest == key.Digest {
                     found = true
                     break
                 }
             }
             if !found {
                 *output = append(*output, fmt.Sprintf("CDS missing for KSK: %s", ksk.PublicKey))
-                group_cdscdnskeys_synced = false
+                result.AddMissing(signer, ksk.ToDS(dns.SHA256).ToCDS())
+            }
+        }
+        for _, key := range cdses[signer] {
+            found := false
+            for _, ksk := range ksks {
+                ds := ksk.ToDS(key.DigestType)
+                if ds != nil && ds.KeyTag == key.KeyTag && ds.Algorithm == key.Algorithm && ds.Digest == key.Digest {
+                    found = true
+                    break
+                }
+            }
+            if !found {
+                *output = append(*output, fmt.Sprintf("CDS needs removal in %s: %d %d %d %s", signer, key.KeyTag, key.Algorithm, key.DigestType, key.Digest))
+                result.AddExtra(signer, key)
             }
         }
     }
 
-    for signer, keys := range cdnskeys {
+    for _, signer := range signers {
         if Config.Get("signer-leaving:"+signer, "") != "" {
             *output = append(*output, fmt.Sprintf("Skipping sync status of %s CDNSKEYs: leaving signer", signer))
             continue
@@ -228,9 +448,9 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
         *output = append(*output, fmt.Sprintf("Check sync status of %s CDNSKEYs", signer))
 
         for _, ksk := range ksks {
+            cdnskey := ksk.ToCDNSKEY()
             found := false
-            for _, key := range keys {
-                cdnskey := ksk.ToCDNSKEY()
+            for _, key := range cdnskeys[signer] {
                 if cdnskey.Flags == key.Flags && cdnskey.Protocol == key.Protocol && cdnskey.Algorithm == key.Algorithm && cdnskey.PublicKey == key.PublicKey {
                     found = true
                     break
@@ -238,36 +458,80 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
             }
             if !found {
                 *output = append(*output, fmt.Sprintf("CDNSKEY missing for KSK: %s", ksk.PublicKey))
-                group_cdscdnskeys_synced = false
+                result.AddMissing(signer, cdnskey)
+            }
+        }
+        for _, key := range cdnskeys[signer] {
+            found := false
+            for _, ksk := range ksks {
+                if ksk.Flags == key.Flags && ksk.Protocol == key.Protocol && ksk.Algorithm == key.Algorithm && ksk.PublicKey == key.PublicKey {
+                    found = true
+                    break
+                }
+            }
+            if !found {
+                *output = append(*output, fmt.Sprintf("CDNSKEY needs removal in %s: %s", signer, key.PublicKey))
+                result.AddExtra(signer, key)
             }
         }
     }
 
-    if group_cdscdnskeys_synced {
-        Config.Set("group-cdscdnskeys-synced:"+args[0], "yes")
-    } else {
-        Config.Remove("group-cdscdnskeys-synced:" + args[0])
-    }
+    return result, nil
+}
 
-    group_nses_synced := true
+// Query the NSes of all signers in a group, returns the NSes per signer and
+// the NSes of all signers mapped by name. The NSes found are only listed in
+// output if it is given.
+func statusNses(fqdn string, output *[]string) (map[string][]*dns.NS, map[string]*dns.NS, error) {
+    msgs, err := statusQuery(fqdn, dns.TypeNS)
+    if err != nil {
+        return nil, nil, err
+    }
 
+    nses := make(map[string][]*dns.NS)
     nsmap := make(map[string]*dns.NS)
-    for _, rrs := range nses {
-        for _, rr := range rrs {
-            nsmap[rr.Ns] = rr
+    for _, signer := range Config.ListGet("signers:" + fqdn) {
+        nses[signer] = []*dns.NS{}
+        for _, a := range msgs[signer].Answer {
+            ns, ok := a.(*dns.NS)
+            if !ok {
+                continue
+            }
+
+            if output != nil {
+                owner := Config.Get("ns-origin:"+ns.Ns, "")
+                if owner != "" {
+                    owner = " (owner: " + owner + ")"
+                }
+
+                *output = append(*output, fmt.Sprintf("%s: found NS %s%s", signer, ns.Ns, owner))
+            }
+
+            nses[signer] = append(nses[signer], ns)
+            nsmap[ns.Ns] = ns
         }
     }
-    nsset := []*dns.NS{}
-    for _, rr := range nsmap {
-        nsset = append(nsset, rr)
+
+    return nses, nsmap, nil
+}
+
+// Check that all signers publish the NSes of all signers and that the NSes
+// of leaving signers have been removed
+func StatusNses(fqdn string, output *[]string) (*StatusResult, error) {
+    nses, nsmap, err := statusNses(fqdn, output)
+    if err != nil {
+        return nil, err
     }
 
-    for signer, keys := range nses {
+    result := NewStatusResult()
+    signers := Config.ListGet("signers:" + fqdn)
+
+    for _, signer := range signers {
         *output = append(*output, fmt.Sprintf("Check sync status of %s NSes", signer))
 
-        for _, ns := range nsset {
+        for _, ns := range nsmap {
             found := false
-            for _, key := range keys {
+            for _, key := range nses[signer] {
                 if ns.Ns == key.Ns {
                     found = true
                     break
@@ -275,7 +539,7 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
             }
             if !found {
                 *output = append(*output, fmt.Sprintf("NS missing: %s", ns.Ns))
-                group_nses_synced = false
+                result.AddMissing(signer, ns)
             }
         }
     }
@@ -283,34 +547,60 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
     for _, signer := range signers {
         if Config.Get("signer-leaving:"+signer, "") != "" {
             leave_ns := Config.Get("signer-ns:"+signer, "")
-            for _, ns := range nsset {
-                if ns.Ns == leave_ns {
-                    *output = append(*output, fmt.Sprintf("  need removal of leaving %s NS: %s", signer, leave_ns))
-                    group_nses_synced = false
+            if _, ok := nsmap[leave_ns]; !ok {
+                continue
+            }
+            *output = append(*output, fmt.Sprintf("  need removal of leaving %s NS: %s", signer, leave_ns))
+            for osigner, keys := range nses {
+                for _, key := range keys {
+                    if key.Ns == leave_ns {
+                        result.AddExtra(osigner, key)
+                    }
                 }
             }
         }
     }
-    if group_nses_synced {
-        Config.Set("group-nses-synced:"+args[0], "yes")
-    } else {
-        Config.Remove("group-nses-synced:" + args[0])
+
+    return result, nil
+}
+
+// The parent is only in sync if the records it is compared with are in sync
+// between the signers, otherwise it may match stale records of one signer.
+// Runs the check of the signers and marks the parent invalid if it fails.
+func statusSignersSynced(check StatusCheck, fqdn, what string, result *StatusResult, output *[]string) error {
+    discard := []string{}
+    status, err := check(fqdn, &discard)
+    if err != nil {
+        return err
+    }
+    if !status.Synced() {
+        *output = append(*output, fmt.Sprintf("  %s of the signers are not in sync", what))
+        result.AddInvalid(StatusParent, what+" of the signers are not in sync")
     }
+    return nil
+}
 
-    parent := Config.Get("parent:"+args[0], "")
+// Check that the DS in the parent matches the CDSes published by the signers
+// and that the CDS/CDNSKEYs are in sync between the signers
+func StatusParentDs(fqdn string, output *[]string) (*StatusResult, error) {
+    parent := Config.Get("parent:"+fqdn, "")
     if parent == "" {
-        return fmt.Errorf("No ip|host for parent of %s", args[0])
+        return nil, fmt.Errorf("No ip|host for parent of %s", fqdn)
     }
 
-    *output = append(*output, fmt.Sprintf("Check sync status of parent %s", parent))
+    msgs, err := statusQuery(fqdn, dns.TypeCDS)
+    if err != nil {
+        return nil, err
+    }
 
-    m := new(dns.Msg)
-    m.SetQuestion(args[0], dns.TypeDS)
-    c := new(dns.Client)
-    r, _, err := c.Exchange(m, parent)
+    *output = append(*output, fmt.Sprintf("Check sync status of parent %s DS", parent))
+
+    r, err := Query(parent, "", fqdn, dns.TypeDS)
     if err != nil {
-        return err
+        return nil, err
     }
+
+    result := NewStatusResult()
     dses := []*dns.DS{}
     removedses := make(map[string]*dns.DS)
     for _, a := range r.Answer {
@@ -325,10 +615,13 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
         removedses[fmt.Sprintf("%d %d %d %s", ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)] = ds
     }
 
-    group_parent_ds_synced := group_cdscdnskeys_synced
     cdsmap := make(map[string]*dns.CDS)
-    for _, keys := range cdses {
-        for _, key := range keys {
+    for _, m := range msgs {
+        for _, a := range m.Answer {
+            key, ok := a.(*dns.CDS)
+            if !ok {
+                continue
+            }
             cdsmap[fmt.Sprintf("%d %d %d %s", key.KeyTag, key.Algorithm, key.DigestType, key.Digest)] = key
             delete(removedses, fmt.Sprintf("%d %d %d %s", key.KeyTag, key.Algorithm, key.DigestType, key.Digest))
         }
@@ -338,27 +631,46 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
     }
     for _, cds := range cdsmap {
         *output = append(*output, fmt.Sprintf("  Missing DS for CDS: %d %d %d %s", cds.KeyTag, cds.Algorithm, cds.DigestType, cds.Digest))
-        group_parent_ds_synced = false
+        ds := cds.DS
+        ds.Hdr.Rrtype = dns.TypeDS
+        result.AddMissing(StatusParent, &ds)
     }
     for _, ds := range removedses {
         *output = append(*output, fmt.Sprintf("  DS needs removal: %d %d %d %s", ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest))
-        group_parent_ds_synced = false
+        result.AddExtra(StatusParent, ds)
     }
-    if group_parent_ds_synced {
-        Config.Set("group-parent-ds-synced:"+args[0], "yes")
-    } else {
-        Config.Remove("group-parent-ds-synced:" + args[0])
+
+    if err := statusSignersSynced(StatusCdscdnskeys, fqdn, "CDS/CDNSKEYs", result, output); err != nil {
+        return nil, err
+    }
+
+    return result, nil
+}
+
+// Check that the NS in the parent matches the NSes published by the signers,
+// that the NSes of leaving signers have been removed and that the NSes are
+// in sync between the signers
+func StatusParentNs(fqdn string, output *[]string) (*StatusResult, error) {
+    parent := Config.Get("parent:"+fqdn, "")
+    if parent == "" {
+        return nil, fmt.Errorf("No ip|host for parent of %s", fqdn)
     }
 
-    m = new(dns.Msg)
-    m.SetQuestion(args[0], dns.TypeNS)
-    r, _, err = c.Exchange(m, parent)
+    _, nsmap, err := statusNses(fqdn, nil)
     if err != nil {
-        return err
+        return nil, err
     }
-    group_parent_ns_synced := group_nses_synced
+
+    *output = append(*output, fmt.Sprintf("Check sync status of parent %s NS", parent))
+
+    r, err := Query(parent, "", fqdn, dns.TypeNS)
+    if err != nil {
+        return nil, err
+    }
+
+    result := NewStatusResult()
     leavingns := make(map[string]bool)
-    for _, signer := range signers {
+    for _, signer := range Config.ListGet("signers:" + fqdn) {
         if Config.Get("signer-leaving:"+signer, "") != "" {
             leavingns[Config.Get("signer-ns:"+signer, "")] = true
         }
@@ -371,7 +683,7 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
 
         if _, ok := leavingns[ns.Ns]; ok {
             *output = append(*output, fmt.Sprintf("  found leaving NS %s, need removal", ns.Ns))
-            group_parent_ns_synced = false
+            result.AddExtra(StatusParent, ns)
         } else {
             *output = append(*output, fmt.Sprintf("  found NS %s", ns.Ns))
         }
@@ -379,15 +691,14 @@ func StatusCmd(args []string, remote bool, output *[]string) error {
         delete(nsmap, ns.Ns)
     }
 
-    for ns, _ := range nsmap {
+    for ns, rr := range nsmap {
         *output = append(*output, fmt.Sprintf("  Missing NS: %s", ns))
-        group_parent_ns_synced = false
+        result.AddMissing(StatusParent, rr)
     }
-    if group_parent_ns_synced {
-        Config.Set("group-parent-ns-synced:"+args[0], "yes")
-    } else {
-        Config.Remove("group-parent-ns-synced:" + args[0])
+
+    if err := statusSignersSynced(StatusNses, fqdn, "NSes", result, output); err != nil {
+        return nil, err
     }
 
-    return nil
+    return result, nil
 }
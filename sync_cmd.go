@@ -26,6 +26,10 @@ func SyncDnskeyCmd(args []string, remote bool, output *[]string) error {
         return fmt.Errorf("group %s has no signers", args[0])
     }
 
+    if GroupKskSigner(args[0]) != "" {
+        return SyncDnskeyModel1(args[0], output)
+    }
+
     signers := Config.ListGet("signers:" + args[0])
 
     dnskeys := make(map[string][]*dns.DNSKEY)
@@ -36,12 +40,7 @@ func SyncDnskeyCmd(args []string, remote bool, output *[]string) error {
             return fmt.Errorf("No ip|host for signer %s", signer)
         }
 
-        m := new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeDNSKEY)
-
-        c := new(dns.Client)
-        r, _, err := c.Exchange(m, ip)
-
+        r, err := Query(ip, signer, args[0], dns.TypeDNSKEY)
         if err != nil {
             return err
         }
@@ -62,6 +61,9 @@ func SyncDnskeyCmd(args []string, remote bool, output *[]string) error {
         }
     }
 
+    // ZSKs retired by their owner should not be synced back to it
+    retired := RetiredZsks(dnskeys)
+
     // for each signer, check every of it's DNSKEY if it needs to be added or removed
     for signer, keys := range dnskeys {
         leaving := Config.Get("signer-leaving:"+signer, "")
@@ -116,6 +118,10 @@ func SyncDnskeyCmd(args []string, remote bool, output *[]string) error {
 
         for _, key := range keys {
             if f := key.Flags & 0x101; f == 256 { // only process ZSK's
+                if _, ok := retired[fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey)]; ok {
+                    *output = append(*output, fmt.Sprintf("- %s (retired)", key.PublicKey))
+                    continue
+                }
                 *output = append(*output, fmt.Sprintf("- %s", key.PublicKey))
 
                 for osigner, okeys := range dnskeys {
@@ -160,6 +166,197 @@ func SyncDnskeyCmd(args []string, remote bool, output *[]string) error {
     return nil
 }
 
+// Sync DNSKEYs for a RFC 8901 Model 1 group, the ZSKs of all signers are
+// added to the KSK signer and the DNSKEY RRset it signs, with its RRSIGs,
+// is then distributed to all other signers. ZSKs retired by their owner are
+// kept by the KSK signer until they are removed by remove-retired-zsks.
+func SyncDnskeyModel1(fqdn string, output *[]string) error {
+    holder := GroupKskSigner(fqdn)
+    if Config.Get("signer-leaving:"+holder, "") != "" {
+        return fmt.Errorf("KSK signer %s of group %s is leaving, set a new KSK signer with group-model", holder, fqdn)
+    }
+
+    dnskeys, err := GroupDnskeys(fqdn)
+    if err != nil {
+        return err
+    }
+    if _, ok := dnskeys[holder]; !ok {
+        return fmt.Errorf("KSK signer %s is not part of group %s", holder, fqdn)
+    }
+
+    for signer, keys := range dnskeys {
+        for _, key := range keys {
+            if f := key.Flags & 0x101; f == 256 {
+                Config.SetIfNotExists("dnskey-origin:"+fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey), signer)
+            }
+        }
+    }
+    retired := RetiredZsks(dnskeys)
+
+    // the ZSKs that should be added to the DNSKEY RRset, those that
+    // originate from signers that are not leaving and that has not been
+    // retired
+    zsks := make(map[string]*dns.DNSKEY)
+    for _, keys := range dnskeys {
+        for _, key := range keys {
+            if f := key.Flags & 0x101; f != 256 {
+                continue
+            }
+            origin := fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey)
+            if _, ok := retired[origin]; ok {
+                continue
+            }
+            if Config.Get("signer-leaving:"+Config.Get("dnskey-origin:"+origin, ""), "") != "" {
+                continue
+            }
+            zsks[origin] = key
+        }
+    }
+
+    *output = append(*output, fmt.Sprintf("Syncing ZSKs to KSK signer %s", holder))
+
+    inserts := []dns.RR{}
+    removes := []dns.RR{}
+    have := make(map[string]bool)
+    for _, key := range dnskeys[holder] {
+        if f := key.Flags & 0x101; f != 256 {
+            continue
+        }
+        origin := fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey)
+        have[origin] = true
+        if _, ok := retired[origin]; ok {
+            *output = append(*output, fmt.Sprintf("  %s (retired, kept until removed)", key.PublicKey))
+            continue
+        }
+        if _, ok := zsks[origin]; !ok {
+            *output = append(*output, fmt.Sprintf("- %s", key.PublicKey))
+            removes = append(removes, key)
+        }
+    }
+    for origin, key := range zsks {
+        if !have[origin] {
+            *output = append(*output, fmt.Sprintf("+ %s", key.PublicKey))
+            inserts = append(inserts, key)
+        }
+    }
+    if len(inserts) > 0 || len(removes) > 0 {
+        updater := GetUpdater(Config.Get("signer-type:"+holder, "nsupdate"))
+        if err := updater.Update(fqdn, holder, &[][]dns.RR{inserts}, &[][]dns.RR{removes}, output); err != nil {
+            return err
+        }
+    }
+
+    return Model1Distribute(fqdn, holder, output)
+}
+
+// Distribute the DNSKEY RRset of the KSK signer of a Model 1 group, and the
+// RRSIGs covering it, to all other signers. Only records that came from the
+// KSK signer are removed, the signers own DNSKEYs and RRSIGs are kept.
+func Model1Distribute(fqdn, holder string, output *[]string) error {
+    r, err := Query(Config.Get("signer:"+holder, ""), holder, fqdn, dns.TypeDNSKEY)
+    if err != nil {
+        return err
+    }
+    rrset, rrsigs := Model1Dnskeys(r)
+    if len(rrsigs) == 0 {
+        return fmt.Errorf("KSK signer %s returned no RRSIG for the DNSKEY RRset of %s", holder, fqdn)
+    }
+
+    for _, signer := range Config.ListGet("signers:" + fqdn) {
+        if signer == holder || Config.Get("signer-leaving:"+signer, "") != "" {
+            continue
+        }
+
+        ip := Config.Get("signer:"+signer, "")
+        r, err := Query(ip, signer, fqdn, dns.TypeDNSKEY)
+        if err != nil {
+            return err
+        }
+        orrset, orrsigs := Model1Dnskeys(r)
+
+        inserts := []dns.RR{}
+        removes := []dns.RR{}
+        for _, rr := range append(rrset, rrsigs...) {
+            if !rrContains(append(orrset, orrsigs...), rr) {
+                inserts = append(inserts, rr)
+            }
+        }
+        for _, rr := range append(orrset, orrsigs...) {
+            if !rrContains(append(rrset, rrsigs...), rr) && Model1Distributed(signer, holder, rrset, orrset, rr) {
+                removes = append(removes, rr)
+            }
+        }
+
+        if len(inserts) == 0 && len(removes) == 0 {
+            *output = append(*output, fmt.Sprintf("  DNSKEY RRset in sync in %s", signer))
+            continue
+        }
+
+        updater := GetUpdater(Config.Get("signer-type:"+signer, "nsupdate"))
+        if err := updater.Update(fqdn, signer, &[][]dns.RR{inserts}, &[][]dns.RR{removes}, output); err != nil {
+            return err
+        }
+        *output = append(*output, fmt.Sprintf("  Distributed DNSKEY RRset to %s", signer))
+    }
+
+    return nil
+}
+
+// Return the DNSKEY RRset and the RRSIGs covering it from a response
+func Model1Dnskeys(r *dns.Msg) ([]dns.RR, []dns.RR) {
+    rrset := []dns.RR{}
+    rrsigs := []dns.RR{}
+    for _, a := range r.Answer {
+        switch rr := a.(type) {
+        case *dns.DNSKEY:
+            rrset = append(rrset, rr)
+        case *dns.RRSIG:
+            if rr.TypeCovered == dns.TypeDNSKEY {
+                rrsigs = append(rrsigs, rr)
+            }
+        }
+    }
+    return rrset, rrsigs
+}
+
+// Check if a record of the DNSKEY RRset of a signer in a Model 1 group came
+// from the KSK signer, holderset is the DNSKEY RRset of the KSK signer and
+// rrset the one of the signer. The ZSKs of other signers and the KSKs of the
+// KSK signer are distributed, and so are RRSIGs that are not made with one
+// of the signers own keys.
+func Model1Distributed(signer, holder string, holderset, rrset []dns.RR, rr dns.RR) bool {
+    switch rr := rr.(type) {
+    case *dns.DNSKEY:
+        origin := fmt.Sprintf("%d-%d-%s", rr.Protocol, rr.Algorithm, rr.PublicKey)
+        if f := rr.Flags & 0x101; f == 256 {
+            return Config.Get("dnskey-origin:"+origin, "") != signer
+        }
+        return Config.Get("ksk-origin:"+origin, "") == holder || rrContains(holderset, rr)
+    case *dns.RRSIG:
+        for _, o := range rrset {
+            key, ok := o.(*dns.DNSKEY)
+            if !ok || key.KeyTag() != rr.KeyTag || key.Algorithm != rr.Algorithm {
+                continue
+            }
+            if !Model1Distributed(signer, holder, holderset, rrset, key) {
+                return false
+            }
+        }
+        return true
+    }
+    return false
+}
+
+// Check if a list of records contains a record
+func rrContains(rrs []dns.RR, rr dns.RR) bool {
+    for _, o := range rrs {
+        if dns.IsDuplicate(o, rr) {
+            return true
+        }
+    }
+    return false
+}
+
 func SyncCdscdnskeysCmd(args []string, remote bool, output *[]string) error {
     if len(args) < 1 {
         return fmt.Errorf("requires <fqdn>")
@@ -183,12 +380,7 @@ func SyncCdscdnskeysCmd(args []string, remote bool, output *[]string) error {
             return fmt.Errorf("No ip|host for signer %s", signer)
         }
 
-        m := new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeDNSKEY)
-
-        c := new(dns.Client)
-        r, _, err := c.Exchange(m, ip)
-
+        r, err := Query(ip, signer, args[0], dns.TypeDNSKEY)
         if err != nil {
             return err
         }
@@ -205,12 +397,18 @@ func SyncCdscdnskeysCmd(args []string, remote bool, output *[]string) error {
         }
     }
 
-    // Create CDS/CDNSKEY records for all DNSKEYs found
+    // Create CDS/CDNSKEY records for all DNSKEYs found, in Model 1 only the
+    // KSKs of the KSK signer are used
+    holder := GroupKskSigner(args[0])
     cdses := []dns.RR{}
     cdnskeys := []dns.RR{}
-    for _, keys := range dnskeys {
+    for signer, keys := range dnskeys {
+        if holder != "" && signer != holder {
+            continue
+        }
         for _, key := range keys {
             if f := key.Flags & 0x101; f == 257 {
+                Config.SetIfNotExists("ksk-origin:"+fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey), signer)
                 cdses = append(cdses, key.ToDS(dns.SHA256).ToCDS())
                 cdnskeys = append(cdnskeys, key.ToCDNSKEY())
             }
@@ -256,10 +454,7 @@ func SyncNsCmd(args []string, remote bool, output *[]string) error {
             return fmt.Errorf("No ip|host for signer %s", signer)
         }
 
-        m := new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeNS)
-        c := new(dns.Client)
-        r, _, err := c.Exchange(m, ip)
+        r, err := Query(ip, signer, args[0], dns.TypeNS)
         if err != nil {
             return err
         }
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+
+    "github.com/miekg/dns"
+)
+
+func syncTestKey(t *testing.T, flags uint16, origin, signer string) *dns.DNSKEY {
+    key := &dns.DNSKEY{
+        Hdr:       dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+        Flags:     flags,
+        Protocol:  3,
+        Algorithm: dns.ECDSAP256SHA256,
+    }
+    if _, err := key.Generate(256); err != nil {
+        t.Fatal(err)
+    }
+    if origin != "" {
+        Config.Set(origin+fmt.Sprintf("%d-%d-%s", key.Protocol, key.Algorithm, key.PublicKey), signer)
+    }
+    return key
+}
+
+func syncTestRrsig(key *dns.DNSKEY) *dns.RRSIG {
+    return &dns.RRSIG{
+        Hdr:         dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+        TypeCovered: dns.TypeDNSKEY,
+        KeyTag:      key.KeyTag(),
+        Algorithm:   key.Algorithm,
+        SignerName:  "example.com.",
+    }
+}
+
+func TestModel1Distributed(t *testing.T) {
+    previous := Config
+    t.Cleanup(func() { Config = previous })
+    Config = NewConfig()
+
+    holderKsk := syncTestKey(t, 257, "ksk-origin:", "h")
+    holderZsk := syncTestKey(t, 256, "dnskey-origin:", "h")
+    oldZsk := syncTestKey(t, 256, "dnskey-origin:", "h")
+    signerZsk := syncTestKey(t, 256, "dnskey-origin:", "s")
+    // a KSK of the signer itself, not tracked in Model 1
+    signerKsk := syncTestKey(t, 257, "", "")
+
+    holderset := []dns.RR{holderKsk, holderZsk, signerZsk}
+    rrset := []dns.RR{holderKsk, holderZsk, oldZsk, signerZsk, signerKsk}
+
+    tests := []struct {
+        name        string
+        rr          dns.RR
+        distributed bool
+    }{
+        {"KSK of the KSK signer", holderKsk, true},
+        {"ZSK of the KSK signer", holderZsk, true},
+        {"removed ZSK of the KSK signer", oldZsk, true},
+        {"ZSK of the signer", signerZsk, false},
+        {"KSK of the signer", signerKsk, false},
+        {"RRSIG of the KSK signer", syncTestRrsig(holderKsk), true},
+        {"RRSIG of the signer", syncTestRrsig(signerKsk), false},
+    }
+
+    for _, test := range tests {
+        if d := Model1Distributed("s", "h", holderset, rrset, test.rr); d != test.distributed {
+            t.Errorf("%s: distributed %v, expected %v", test.name, d, test.distributed)
+        }
+    }
+}
@@ -28,7 +28,7 @@ func TestDesecCmd(args []string, remote bool, output *[]string) error {
         zone = zone[:len(zone)-1]
     }
 
-    token := Config.Get("desectoken-"+token_name, "")
+    token := GetSecret("desectoken-" + token_name)
     if token == "" {
         return fmt.Errorf("Missing deSEC token %s, use conf-set desectoken-<name> <token>", token_name)
     }
@@ -51,7 +51,7 @@ func TestDesecCmd(args []string, remote bool, output *[]string) error {
 
     *output = append(*output, "Sending POST for creation of "+id)
 
-    req, err := http.NewRequest("POST", fmt.Sprintf("https://desec.io/api/v1/domains/%s/rrsets/", zone), bytes.NewReader(body))
+    req, err := http.NewRequest("POST", fmt.Sprintf("%s/domains/%s/rrsets/", DesecApi(), zone), bytes.NewReader(body))
     if err != nil {
         return err
     }
@@ -76,7 +76,7 @@ func TestDesecCmd(args []string, remote bool, output *[]string) error {
 
     *output = append(*output, "Sending DELETE")
 
-    req, err = http.NewRequest("DELETE", fmt.Sprintf("https://desec.io/api/v1/domains/%s/rrsets/%s/TXT/", zone, id), nil)
+    req, err = http.NewRequest("DELETE", fmt.Sprintf("%s/domains/%s/rrsets/%s/TXT/", DesecApi(), zone, id), nil)
     if err != nil {
         return err
     }
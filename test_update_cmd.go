@@ -23,9 +23,9 @@ func TestUpdateCmd(args []string, remote bool, output *[]string) error {
     zone := args[1]
     tsigkey := args[2]
 
-    secret := Config.Get("tsigkey-"+tsigkey, "")
-    if secret == "" {
-        return fmt.Errorf("Missing TSIG key %s, use conf-set tsigkey-<name> <secret>", tsigkey)
+    algorithm, secret, err := GetTsigKey(tsigkey)
+    if err != nil {
+        return fmt.Errorf("%s, use conf-set tsigkey-<name> <secret>", err)
     }
 
     b := uuid.New()
@@ -40,14 +40,14 @@ func TestUpdateCmd(args []string, remote bool, output *[]string) error {
     m := new(dns.Msg)
     m.SetUpdate(zone)
     m.Insert(rrs)
-    m.SetTsig(tsigkey+".", dns.HmacSHA256, 300, time.Now().Unix())
+    m.SetTsig(tsigkey+".", algorithm, 300, time.Now().Unix())
 
     *output = append(*output, m.String())
 
     c := new(dns.Client)
     c.TsigSecret = map[string]string{tsigkey + ".": secret}
     in, rtt, err := c.Exchange(m, server)
-    if err != nil {
+    if err := CheckUpdateResponse(server, in, err); err != nil {
         return err
     }
 
@@ -57,12 +57,12 @@ func TestUpdateCmd(args []string, remote bool, output *[]string) error {
     m = new(dns.Msg)
     m.SetUpdate(zone)
     m.Remove(rrs)
-    m.SetTsig(tsigkey+".", dns.HmacSHA256, 300, time.Now().Unix())
+    m.SetTsig(tsigkey+".", algorithm, 300, time.Now().Unix())
 
     *output = append(*output, m.String())
 
     in, rtt, err = c.Exchange(m, server)
-    if err != nil {
+    if err := CheckUpdateResponse(server, in, err); err != nil {
         return err
     }
 
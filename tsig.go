@@ -0,0 +1,276 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "strings"
+
+    "github.com/miekg/dns"
+)
+
+const TsigDefaultAlgorithm = "hmac-sha256"
+
+var TsigAlgorithms = map[string]string{
+    "hmac-sha1":   dns.HmacSHA1,
+    "hmac-sha224": dns.HmacSHA224,
+    "hmac-sha256": dns.HmacSHA256,
+    "hmac-sha384": dns.HmacSHA384,
+    "hmac-sha512": dns.HmacSHA512,
+}
+
+// Return the dns package algorithm name for a TSIG algorithm given as
+// in BIND/Knot configuration (hmac-sha256 etc)
+func TsigAlgorithm(name string) (string, error) {
+    alg, ok := TsigAlgorithms[strings.TrimSuffix(strings.ToLower(name), ".")]
+    if !ok {
+        return "", fmt.Errorf("Unsupported TSIG algorithm %s", name)
+    }
+    return alg, nil
+}
+
+// Return the algorithm and secret of a TSIG key, the algorithm is taken from
+// tsig-algorithm:<name> and defaults to hmac-sha256
+func GetTsigKey(name string) (algorithm, secret string, err error) {
+    secret = GetSecret("tsigkey-" + name)
+    if secret == "" {
+        return "", "", fmt.Errorf("Missing TSIG key secret for %s", name)
+    }
+
+    algorithm, err = TsigAlgorithm(Config.Get("tsig-algorithm:"+name, TsigDefaultAlgorithm))
+    if err != nil {
+        return "", "", fmt.Errorf("TSIG key %s: %s", name, err)
+    }
+
+    return algorithm, secret, nil
+}
+
+// The algorithm of a TSIG key used to be set with tsigkey-algorithm-<name>,
+// which by the key alone can not be told from the secret of a key named
+// algorithm-<name>. Returns tsig-algorithm:<name> if the value is an
+// algorithm, which is not base64 and can not be a secret, otherwise the key
+// unchanged.
+func TsigAlgorithmKey(key, value string) string {
+    if !strings.HasPrefix(key, "tsigkey-algorithm-") {
+        return key
+    }
+    if _, err := TsigAlgorithm(value); err != nil {
+        return key
+    }
+    return "tsig-algorithm:" + strings.TrimPrefix(key, "tsigkey-algorithm-")
+}
+
+// Move the algorithms set with tsigkey-algorithm-<name> to
+// tsig-algorithm:<name>, see TsigAlgorithmKey
+func TsigMigrateAlgorithms() {
+    for _, key := range Config.PrefixKeys("tsigkey-algorithm-") {
+        value := Config.Get(key, "")
+        algorithm := TsigAlgorithmKey(key, value)
+        if algorithm == key {
+            continue
+        }
+        Config.SetIfNotExists(algorithm, value)
+        Config.Remove(key)
+        log.Printf("Moved TSIG algorithm %s to %s", key, algorithm)
+    }
+}
+
+type TsigKey struct {
+    Name      string
+    Algorithm string
+    Secret    string
+}
+
+// Parse BIND key clauses:
+//
+//    key "name" {
+//        algorithm hmac-sha256;
+//        secret "base64";
+//    };
+func ParseBindKeys(data string) ([]*TsigKey, error) {
+    tokens, err := bindTokens(data)
+    if err != nil {
+        return nil, err
+    }
+
+    keys := []*TsigKey{}
+    for i := 0; i < len(tokens); i++ {
+        if tokens[i] != "key" {
+            // skip other statements
+            depth := 0
+            for ; i < len(tokens); i++ {
+                if tokens[i] == "{" {
+                    depth++
+                } else if tokens[i] == "}" {
+                    depth--
+                } else if tokens[i] == ";" && depth == 0 {
+                    break
+                }
+            }
+            continue
+        }
+
+        if i+2 >= len(tokens) || tokens[i+2] != "{" {
+            return nil, fmt.Errorf("key clause missing name or {")
+        }
+        key := &TsigKey{Name: strings.TrimSuffix(tokens[i+1], ".")}
+        i += 3
+
+        for ; i < len(tokens) && tokens[i] != "}"; i++ {
+            if tokens[i] == ";" {
+                continue
+            }
+            if i+2 >= len(tokens) || tokens[i+2] != ";" {
+                return nil, fmt.Errorf("key %s: invalid statement %s", key.Name, tokens[i])
+            }
+            switch tokens[i] {
+            case "algorithm":
+                key.Algorithm = strings.ToLower(tokens[i+1])
+            case "secret":
+                key.Secret = tokens[i+1]
+            default:
+                return nil, fmt.Errorf("key %s: unknown statement %s", key.Name, tokens[i])
+            }
+            i += 2
+        }
+        if i >= len(tokens) {
+            return nil, fmt.Errorf("key %s: missing }", key.Name)
+        }
+        if i+1 < len(tokens) && tokens[i+1] == ";" {
+            i++
+        }
+
+        if key.Name == "" || key.Secret == "" {
+            return nil, fmt.Errorf("key %s: missing name or secret", key.Name)
+        }
+        if key.Algorithm == "" {
+            key.Algorithm = TsigDefaultAlgorithm
+        }
+        if _, err := TsigAlgorithm(key.Algorithm); err != nil {
+            return nil, fmt.Errorf("key %s: %s", key.Name, err)
+        }
+
+        keys = append(keys, key)
+    }
+
+    return keys, nil
+}
+
+// Split BIND configuration into tokens, quoted strings are returned without
+// quotes and comments are removed
+func bindTokens(data string) ([]string, error) {
+    tokens := []string{}
+    for i := 0; i < len(data); {
+        switch c := data[i]; {
+        case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+            i++
+        case c == '#' || strings.HasPrefix(data[i:], "//"):
+            for i < len(data) && data[i] != '\n' {
+                i++
+            }
+        case strings.HasPrefix(data[i:], "/*"):
+            end := strings.Index(data[i+2:], "*/")
+            if end < 0 {
+                return nil, fmt.Errorf("unterminated comment")
+            }
+            i += end + 4
+        case c == '{' || c == '}' || c == ';':
+            tokens = append(tokens, string(c))
+            i++
+        case c == '"':
+            end := strings.IndexByte(data[i+1:], '"')
+            if end < 0 {
+                return nil, fmt.Errorf("unterminated string")
+            }
+            tokens = append(tokens, data[i+1:i+1+end])
+            i += end + 2
+        default:
+            start := i
+            for i < len(data) && !strings.ContainsRune(" \t\r\n{};\"", rune(data[i])) {
+                i++
+            }
+            tokens = append(tokens, data[start:i])
+        }
+    }
+    return tokens, nil
+}
+
+// Parse Knot DNS key sections:
+//
+//    key:
+//      - id: name
+//        algorithm: hmac-sha256
+//        secret: base64
+func ParseKnotKeys(data string) ([]*TsigKey, error) {
+    keys := []*TsigKey{}
+    var key *TsigKey
+    in_key := false
+
+    for n, line := range strings.Split(data, "\n") {
+        if i := strings.IndexByte(line, '#'); i >= 0 {
+            line = line[:i]
+        }
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+
+        // a new top level section
+        if line[0] != ' ' && line[0] != '\t' && line[0] != '-' {
+            in_key = strings.TrimSpace(line) == "key:"
+            key = nil
+            continue
+        }
+        if !in_key {
+            continue
+        }
+
+        entry := strings.TrimSpace(line)
+        if strings.HasPrefix(entry, "- ") {
+            key = &TsigKey{}
+            keys = append(keys, key)
+            entry = strings.TrimSpace(entry[2:])
+        }
+        if key == nil {
+            return nil, fmt.Errorf("line %d: key entry without -", n+1)
+        }
+
+        i := strings.IndexByte(entry, ':')
+        if i < 0 {
+            return nil, fmt.Errorf("line %d: invalid key entry", n+1)
+        }
+        value := strings.Trim(strings.TrimSpace(entry[i+1:]), "\"'")
+        switch strings.TrimSpace(entry[:i]) {
+        case "id":
+            key.Name = strings.TrimSuffix(value, ".")
+        case "algorithm":
+            key.Algorithm = strings.ToLower(value)
+        case "secret":
+            key.Secret = value
+        default:
+            return nil, fmt.Errorf("line %d: unknown key option %s", n+1, entry[:i])
+        }
+    }
+
+    for _, key := range keys {
+        if key.Name == "" || key.Secret == "" {
+            return nil, fmt.Errorf("key %s: missing id or secret", key.Name)
+        }
+        if key.Algorithm == "" {
+            key.Algorithm = TsigDefaultAlgorithm
+        }
+        if _, err := TsigAlgorithm(key.Algorithm); err != nil {
+            return nil, fmt.Errorf("key %s: %s", key.Name, err)
+        }
+    }
+
+    return keys, nil
+}
+
+// Parse TSIG keys in either Knot DNS or BIND format
+func ParseTsigKeys(data string) ([]*TsigKey, error) {
+    for _, line := range strings.Split(data, "\n") {
+        if strings.TrimSpace(line) == "key:" && !strings.HasPrefix(line, " ") {
+            return ParseKnotKeys(data)
+        }
+    }
+    return ParseBindKeys(data)
+}
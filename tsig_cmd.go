@@ -0,0 +1,75 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+)
+
+func init() {
+    Command["tsig-import"] = TsigImportCmd
+
+    CommandHelp["tsig-import"] = "Import TSIG keys from a BIND or Knot key file and optionally use it for a signer, requires <file> [signer name] [TSIG key]"
+}
+
+// The file is read on the host running the command, remote callers would be
+// able to read files on the daemon host so they are refused
+func TsigImportCmd(args []string, remote bool, output *[]string) error {
+    if remote {
+        return ErrNoRemoteCall
+    }
+
+    if len(args) < 1 {
+        return fmt.Errorf("requires <file> [signer name] [TSIG key]")
+    }
+
+    b, err := ioutil.ReadFile(args[0])
+    if err != nil {
+        return err
+    }
+
+    keys, err := ParseTsigKeys(string(b))
+    if err != nil {
+        return fmt.Errorf("%s: %s", args[0], err)
+    }
+    if len(keys) == 0 {
+        return fmt.Errorf("%s: no keys found", args[0])
+    }
+
+    // find the key to use for the signer before changing anything
+    signer_key := ""
+    if len(args) > 1 {
+        if !Config.Exists("signer:" + args[1]) {
+            return fmt.Errorf("signer %s does not exist", args[1])
+        }
+
+        if len(args) > 2 {
+            for _, key := range keys {
+                if key.Name == args[2] {
+                    signer_key = key.Name
+                    break
+                }
+            }
+            if signer_key == "" {
+                return fmt.Errorf("%s: key %s not found", args[0], args[2])
+            }
+        } else if len(keys) == 1 {
+            signer_key = keys[0].Name
+        } else {
+            return fmt.Errorf("%s: contains %d keys, specify which TSIG key to use for signer %s", args[0], len(keys), args[1])
+        }
+    }
+
+    for _, key := range keys {
+        if err := SetSecret("tsigkey-"+key.Name, key.Secret); err != nil {
+            return err
+        }
+        Config.Set("tsig-algorithm:"+key.Name, key.Algorithm)
+        *output = append(*output, fmt.Sprintf("TSIG key %s (%s) imported", key.Name, key.Algorithm))
+    }
+
+    if signer_key != "" {
+        return SignerTsigCmd([]string{args[1], signer_key}, remote, output)
+    }
+
+    return nil
+}
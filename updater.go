@@ -1,6 +1,7 @@
 package main
 
 import (
+    "fmt"
     "log"
 
     "github.com/miekg/dns"
@@ -27,3 +28,70 @@ func GetUpdater(type_ string) Updater {
     }
     return updater
 }
+
+// UpdateError is returned by an Updater when a signer did not accept an
+// update, either by answering with a rcode other then NOERROR or with a
+// response that failed TSIG verification
+type UpdateError struct {
+    Signer        string
+    Rcode         int
+    ExtendedError string
+    TsigError     error
+}
+
+func (e *UpdateError) Error() string {
+    s := fmt.Sprintf("Update refused by signer %s: rcode %s", e.Signer, dns.RcodeToString[e.Rcode])
+    if e.ExtendedError != "" {
+        s += ", extended error " + e.ExtendedError
+    }
+    if e.TsigError != nil {
+        s += ", TSIG " + e.TsigError.Error()
+    }
+    return s
+}
+
+func (e *UpdateError) Unwrap() error {
+    return e.TsigError
+}
+
+// Check the response of a dynamic update, returns an UpdateError if the
+// update was not successful
+func CheckUpdateResponse(signer string, in *dns.Msg, err error) error {
+    if err != nil {
+        switch err {
+        case dns.ErrSig, dns.ErrTime, dns.ErrSecret, dns.ErrKeyAlg:
+            rcode := dns.RcodeNotAuth
+            if in != nil {
+                rcode = in.Rcode
+            }
+            return &UpdateError{Signer: signer, Rcode: rcode, ExtendedError: extendedError(in), TsigError: err}
+        }
+        return err
+    }
+
+    if in.Rcode != dns.RcodeSuccess {
+        e := &UpdateError{Signer: signer, Rcode: in.Rcode, ExtendedError: extendedError(in)}
+        if t := in.IsTsig(); t != nil && t.Error != dns.RcodeSuccess {
+            e.TsigError = fmt.Errorf("error %s", dns.RcodeToString[int(t.Error)])
+        }
+        return e
+    }
+
+    return nil
+}
+
+func extendedError(in *dns.Msg) string {
+    if in == nil {
+        return ""
+    }
+    opt := in.IsEdns0()
+    if opt == nil {
+        return ""
+    }
+    for _, o := range opt.Option {
+        if ede, ok := o.(*dns.EDNS0_EDE); ok {
+            return ede.String()
+        }
+    }
+    return ""
+}
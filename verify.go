@@ -0,0 +1,88 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+const VerifyDefaultMinValidity = "24h"
+
+// Split the answer of a response into the RRset of qtype and the RRSIGs
+// covering it
+func SignedRRset(r *dns.Msg, qtype uint16) ([]dns.RR, []*dns.RRSIG) {
+    rrset := []dns.RR{}
+    rrsigs := []*dns.RRSIG{}
+    for _, a := range r.Answer {
+        if rrsig, ok := a.(*dns.RRSIG); ok {
+            if rrsig.TypeCovered == qtype {
+                rrsigs = append(rrsigs, rrsig)
+            }
+            continue
+        }
+        if a.Header().Rrtype == qtype {
+            rrset = append(rrset, a)
+        }
+    }
+    return rrset, rrsigs
+}
+
+// Verify that an RRset is signed by one of the given keys with a signature
+// that is valid for at least sig-min-validity (default 24h), an empty RRset
+// needs no signature. The error contains the reason for each RRSIG that
+// did not pass.
+func VerifyRRset(rrset []dns.RR, rrsigs []*dns.RRSIG, keys []*dns.DNSKEY) error {
+    if len(rrset) == 0 {
+        return nil
+    }
+    if len(rrsigs) == 0 {
+        return fmt.Errorf("no RRSIG")
+    }
+
+    minValidity, err := time.ParseDuration(Config.Get("sig-min-validity", VerifyDefaultMinValidity))
+    if err != nil {
+        return fmt.Errorf("invalid sig-min-validity: %s", err)
+    }
+
+    now := time.Now()
+    reasons := []string{}
+    for _, rrsig := range rrsigs {
+        // Key tags are not unique, try every key with the tag before
+        // declaring the RRSIG invalid
+        found := false
+        var verifyErr error
+        for _, key := range keys {
+            if key.KeyTag() != rrsig.KeyTag || key.Algorithm != rrsig.Algorithm {
+                continue
+            }
+            found = true
+
+            if verifyErr = rrsig.Verify(key, rrset); verifyErr == nil {
+                break
+            }
+        }
+        if !found {
+            reasons = append(reasons, fmt.Sprintf("RRSIG %d by unknown key", rrsig.KeyTag))
+            continue
+        }
+        if verifyErr != nil {
+            reasons = append(reasons, fmt.Sprintf("RRSIG %d invalid: %s", rrsig.KeyTag, verifyErr))
+            continue
+        }
+
+        if !rrsig.ValidityPeriod(now) {
+            reasons = append(reasons, fmt.Sprintf("RRSIG %d expired or not yet valid", rrsig.KeyTag))
+            continue
+        }
+        left := time.Duration(rrsig.Expiration-uint32(now.Unix())) * time.Second
+        if left < minValidity {
+            reasons = append(reasons, fmt.Sprintf("RRSIG %d expires in %s", rrsig.KeyTag, left))
+            continue
+        }
+        return nil
+    }
+
+    return fmt.Errorf("%s", strings.Join(reasons, ", "))
+}
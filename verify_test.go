@@ -0,0 +1,101 @@
+package main
+
+import (
+    "crypto"
+    "encoding/base64"
+    "testing"
+    "time"
+
+    "github.com/miekg/dns"
+)
+
+func verifyTestKey(t *testing.T) (*dns.DNSKEY, *dns.RRSIG, []dns.RR) {
+    key := &dns.DNSKEY{
+        Hdr:       dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+        Flags:     257,
+        Protocol:  3,
+        Algorithm: dns.ECDSAP256SHA256,
+    }
+    priv, err := key.Generate(256)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    rr, err := dns.NewRR("example.com. 3600 IN NS ns1.example.net.")
+    if err != nil {
+        t.Fatal(err)
+    }
+    rrset := []dns.RR{rr}
+
+    now := time.Now()
+    rrsig := &dns.RRSIG{
+        Hdr:        dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+        KeyTag:     key.KeyTag(),
+        SignerName: "example.com.",
+        Algorithm:  key.Algorithm,
+        Inception:  uint32(now.Add(-time.Hour).Unix()),
+        Expiration: uint32(now.Add(7 * 24 * time.Hour).Unix()),
+    }
+    if err := rrsig.Sign(priv.(crypto.Signer), rrset); err != nil {
+        t.Fatal(err)
+    }
+
+    return key, rrsig, rrset
+}
+
+// Return a different key with the same key tag, swapping two bytes of the
+// same parity keeps the checksum of RFC 4034 Appendix B
+func verifyTestCollision(t *testing.T, key *dns.DNSKEY) *dns.DNSKEY {
+    b, err := base64.StdEncoding.DecodeString(key.PublicKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i+2 < len(b); i++ {
+        if b[i] != b[i+2] {
+            b[i], b[i+2] = b[i+2], b[i]
+            break
+        }
+    }
+
+    other := *key
+    other.PublicKey = base64.StdEncoding.EncodeToString(b)
+    if other.PublicKey == key.PublicKey || other.KeyTag() != key.KeyTag() {
+        t.Fatal("could not create a key with the same key tag")
+    }
+    return &other
+}
+
+func TestVerifyRRset(t *testing.T) {
+    previous := Config
+    t.Cleanup(func() { Config = previous })
+    Config = NewConfig()
+
+    key, rrsig, rrset := verifyTestKey(t)
+    other, _, _ := verifyTestKey(t)
+    collision := verifyTestCollision(t, key)
+
+    tests := []struct {
+        name string
+        keys []*dns.DNSKEY
+        ok   bool
+    }{
+        {"signing key", []*dns.DNSKEY{key}, true},
+        {"key tag collision first", []*dns.DNSKEY{collision, key}, true},
+        {"key tag collision last", []*dns.DNSKEY{key, collision}, true},
+        {"only key tag collision", []*dns.DNSKEY{collision}, false},
+        {"unknown key", []*dns.DNSKEY{other}, false},
+    }
+
+    for _, test := range tests {
+        err := VerifyRRset(rrset, []*dns.RRSIG{rrsig}, test.keys)
+        if (err == nil) != test.ok {
+            t.Errorf("%s: VerifyRRset returned %v", test.name, err)
+        }
+    }
+
+    // a signature that expires before sig-min-validity does not pass
+    Config.Set("sig-min-validity", "240h")
+    if err := VerifyRRset(rrset, []*dns.RRSIG{rrsig}, []*dns.DNSKEY{key}); err == nil {
+        t.Error("RRSIG expiring before sig-min-validity passed")
+    }
+}
@@ -10,9 +10,13 @@ import (
 func init() {
     Command["wait-ds"] = WaitDsCmd
     Command["wait-ns"] = WaitNsCmd
+    Command["wait-dnskey"] = WaitDnskeyCmd
+    Command["wait-zsk"] = WaitZskCmd
 
     CommandHelp["wait-ds"] = "Gather DNSKEYs and DSes for a group, use largest TTL * 2 and set a waiting time, requires <fqdn>"
     CommandHelp["wait-ns"] = "Gather NSes for a group, use largest TTL * 2 and set a waiting time, requires <fqdn>"
+    CommandHelp["wait-dnskey"] = "Gather DNSKEYs for a group, use largest TTL * 2 and set a waiting time, requires <fqdn>"
+    CommandHelp["wait-zsk"] = "Gather signatures for a group, use largest TTL * 2 and set a waiting time for retired ZSKs, requires <fqdn>"
 }
 
 func WaitDsCmd(args []string, remote bool, output *[]string) error {
@@ -46,10 +50,7 @@ func WaitDsCmd(args []string, remote bool, output *[]string) error {
             return fmt.Errorf("No ip|host for signer %s", signer)
         }
 
-        m := new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeDNSKEY)
-        c := new(dns.Client)
-        r, _, err := c.Exchange(m, ip)
+        r, err := Query(ip, signer, args[0], dns.TypeDNSKEY)
         if err != nil {
             return err
         }
@@ -72,10 +73,7 @@ func WaitDsCmd(args []string, remote bool, output *[]string) error {
         return fmt.Errorf("No ip|host for parent of %s", args[0])
     }
 
-    m := new(dns.Msg)
-    m.SetQuestion(args[0], dns.TypeDS)
-    c := new(dns.Client)
-    r, _, err := c.Exchange(m, parent)
+    r, err := Query(parent, "", args[0], dns.TypeDS)
     if err != nil {
         return err
     }
@@ -134,10 +132,7 @@ func WaitNsCmd(args []string, remote bool, output *[]string) error {
             return fmt.Errorf("No ip|host for signer %s", signer)
         }
 
-        m := new(dns.Msg)
-        m.SetQuestion(args[0], dns.TypeNS)
-        c := new(dns.Client)
-        r, _, err := c.Exchange(m, ip)
+        r, err := Query(ip, signer, args[0], dns.TypeNS)
         if err != nil {
             return err
         }
@@ -160,10 +155,7 @@ func WaitNsCmd(args []string, remote bool, output *[]string) error {
         return fmt.Errorf("No ip|host for parent of %s", args[0])
     }
 
-    m := new(dns.Msg)
-    m.SetQuestion(args[0], dns.TypeNS)
-    c := new(dns.Client)
-    r, _, err := c.Exchange(m, parent)
+    r, err := Query(parent, "", args[0], dns.TypeNS)
     if err != nil {
         return err
     }
@@ -190,3 +182,105 @@ func WaitNsCmd(args []string, remote bool, output *[]string) error {
 
     return nil
 }
+
+func WaitDnskeyCmd(args []string, remote bool, output *[]string) error {
+    if len(args) < 1 {
+        return fmt.Errorf("requires <fqdn>")
+    }
+
+    wait_until := Config.Get("group-wait-dnskey:"+args[0], "")
+    if wait_until != "" {
+        until, err := time.Parse(time.RFC3339, wait_until)
+        if err != nil {
+            return err
+        }
+
+        *output = append(*output, fmt.Sprintf("Wait until %s (%s)", until.String(), time.Until(until).String()))
+
+        return nil
+    }
+
+    dnskeys, err := GroupDnskeys(args[0])
+    if err != nil {
+        return err
+    }
+
+    var ttl uint32
+
+    for _, keys := range dnskeys {
+        for _, dnskey := range keys {
+            if dnskey.Header().Ttl > ttl {
+                ttl = dnskey.Header().Ttl
+            }
+        }
+    }
+
+    *output = append(*output, fmt.Sprintf("Largest TTL %d", ttl))
+
+    until := time.Now().Add((time.Duration(ttl*2) * time.Second))
+
+    *output = append(*output, fmt.Sprintf("Wait until %s (%s)", until.String(), time.Until(until).String()))
+
+    Config.Set("group-wait-dnskey:"+args[0], until.Format(time.RFC3339))
+
+    return nil
+}
+
+func WaitZskCmd(args []string, remote bool, output *[]string) error {
+    if len(args) < 1 {
+        return fmt.Errorf("requires <fqdn>")
+    }
+
+    wait_until := Config.Get("group-wait-zsk:"+args[0], "")
+    if wait_until != "" {
+        until, err := time.Parse(time.RFC3339, wait_until)
+        if err != nil {
+            return err
+        }
+
+        *output = append(*output, fmt.Sprintf("Wait until %s (%s)", until.String(), time.Until(until).String()))
+
+        return nil
+    }
+
+    if !Config.Exists("signers:" + args[0]) {
+        return fmt.Errorf("group %s has no signers", args[0])
+    }
+
+    signers := Config.ListGet("signers:" + args[0])
+
+    var ttl uint32
+
+    for _, signer := range signers {
+        ip := Config.Get("signer:"+signer, "")
+        if ip == "" {
+            return fmt.Errorf("No ip|host for signer %s", signer)
+        }
+
+        for _, qtype := range []uint16{dns.TypeSOA, dns.TypeDNSKEY, dns.TypeNS} {
+            r, err := Query(ip, signer, args[0], qtype)
+            if err != nil {
+                return err
+            }
+
+            for _, a := range r.Answer {
+                if a.Header().Ttl > ttl {
+                    ttl = a.Header().Ttl
+                }
+                if rrsig, ok := a.(*dns.RRSIG); ok && rrsig.OrigTtl > ttl {
+                    ttl = rrsig.OrigTtl
+                }
+            }
+        }
+    }
+
+    *output = append(*output, fmt.Sprintf("Largest TTL %d", ttl))
+
+    until := time.Now().Add((time.Duration(ttl*2) * time.Second))
+
+    *output = append(*output, fmt.Sprintf("Wait until %s (%s)", until.String(), time.Until(until).String()))
+
+    Config.Set("group-wait-zsk:"+args[0], until.Format(time.RFC3339))
+
+    return nil
+}
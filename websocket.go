@@ -99,6 +99,41 @@ func WsWaitUntil(fqdn, left string) {
     ClientsLock.Unlock()
 }
 
+type workflowStage struct {
+    Name    string `json:"name"`
+    Next    string `json:"next"`
+    Retry   string `json:"retry,omitempty"`
+    Failure string `json:"failure"`
+}
+type workflow struct {
+    Name   string          `json:"name"`
+    Stages []workflowStage `json:"stages"`
+}
+type workflows struct {
+    Workflows []workflow `json:"workflows"`
+}
+
+// Send the automation workflows to a client
+func WsWorkflows(c *Client) {
+    w := &workflows{}
+    for _, name := range AutomateWorkflows() {
+        wf := workflow{Name: name}
+        for _, s := range AutomateWorkflowStages(name) {
+            failure := s.Failure
+            if failure == "" {
+                failure = AutomateError
+            }
+            wf.Stages = append(wf.Stages, workflowStage{Name: s.Name, Next: s.Next, Retry: s.Retry, Failure: failure})
+        }
+        w.Workflows = append(w.Workflows, wf)
+    }
+    b, err := json.Marshal(w)
+    if err != nil {
+        log.Fatal(err)
+    }
+    c.send <- b
+}
+
 func (c *Client) readPump() {
     defer func() {
         log.Println("lost websocket connection", c.conn.RemoteAddr().String())
@@ -178,7 +213,9 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
     go client.writePump()
     go client.readPump()
 
-    DaemonLock.Lock()
+    WsWorkflows(client)
+
+    ConfigLock.RLock()
     for _, g := range Config.ListGet("groups") {
         signers := make(map[string]bool)
         for _, s := range Config.ListGet("signers:" + g) {
@@ -190,5 +227,5 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
         }
         WsStatus(g, Config.Get("automate-stage:"+g, ""), signers)
     }
-    DaemonLock.Unlock()
+    ConfigLock.RUnlock()
 }